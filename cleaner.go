@@ -1,36 +1,179 @@
 package taskworker
 
-// Cleaner is a task cleaner to a specific command
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gitlab.com/mandalore/go-app/app"
+	"gitlab.com/vredens/go-logger"
+)
+
+// CleanerOption is the abstract functional-parameter type used for cleaner configuration.
+type CleanerOption func(*Cleaner)
+
+// CleanerStats holds what a cleaner did for a command since it started.
+type CleanerStats struct {
+	Runs      int64
+	Reaped    int64
+	Requeued  int64
+	LastRunAt time.Time
+	LastError string
+}
+
+type schedule struct {
+	command   string
+	interval  time.Duration
+	retention time.Duration
+	stale     time.Duration
+}
+
+// Cleaner is a task cleaner which periodically maintains the tasks of the scheduled commands.
 type Cleaner struct {
-	stopChan chan bool
-	storage  TaskStorage
+	mux       *sync.Mutex
+	once      *sync.Once
+	stopChan  chan bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+	storage   TaskStorage
+	schedules []schedule
+	stats     map[string]CleanerStats
+	logger    logger.Logger
+}
+
+// WithCleanerLogger allows you to configure the logger.
+func WithCleanerLogger(logger logger.Logger) CleanerOption {
+	return func(c *Cleaner) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+// WithSchedule adds a command to be maintained every interval. Completed tasks older than retention are removed and
+// tasks which started more than stale ago and whose lease expired are moved back to the 'todo' state, even if no
+// receiver for the command is running to reclaim them. Tasks whose lease is still alive are never requeued. A retention
+// or stale of 0 disables that part of the maintenance.
+func WithSchedule(command string, interval, retention, stale time.Duration) CleanerOption {
+	return func(c *Cleaner) {
+		if interval > 0 {
+			c.schedules = append(c.schedules, schedule{
+				command:   command,
+				interval:  interval,
+				retention: retention,
+				stale:     stale,
+			})
+		}
+	}
 }
 
 // NewCleaner creates a new cleaner
-func NewCleaner(storage TaskStorage) *Cleaner {
-	return &Cleaner{
+func NewCleaner(storage TaskStorage, opts ...CleanerOption) *Cleaner {
+	c := &Cleaner{
+		mux:      &sync.Mutex{},
+		once:     &sync.Once{},
 		stopChan: make(chan bool),
 		storage:  storage,
+		stats:    make(map[string]CleanerStats),
+		logger:   logger.SpawnMute(),
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	return c
 }
 
 // Start starts the process
 func (c *Cleaner) Start() error {
+	wg := &sync.WaitGroup{}
+
+	for _, s := range c.schedules {
+		wg.Add(1)
+
+		go func(s schedule) {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-time.After(s.interval):
+					c.clean(s)
+				case <-c.stopChan:
+					return
+				}
+			}
+		}(s)
+	}
+
 	<-c.stopChan
+	wg.Wait()
 
 	return nil
 }
 
+// Stats returns what the cleaner did for command so far.
+func (c *Cleaner) Stats(command string) CleanerStats {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	return c.stats[command]
+}
+
+func (c *Cleaner) clean(s schedule) {
+	var reaped, requeued int
+	var failure error
+
+	if s.retention > 0 {
+		n, err := c.storage.Cleanup(c.ctx, s.command, s.retention)
+		if err != nil {
+			c.logger.WithData(app.KV{"command": s.command, "cause": app.StringifyError(err)}).Warn("failed to cleanup tasks")
+			failure = err
+		}
+		reaped = n
+	}
+
+	if s.stale > 0 {
+		n, err := c.storage.Retry(c.ctx, s.command, s.stale)
+		if err != nil {
+			c.logger.WithData(app.KV{"command": s.command, "cause": app.StringifyError(err)}).Warn("failed to retry stale tasks")
+			failure = err
+		}
+		requeued = n
+	}
+
+	c.mux.Lock()
+	stats := c.stats[s.command]
+	stats.Runs++
+	stats.Reaped += int64(reaped)
+	stats.Requeued += int64(requeued)
+	stats.LastRunAt = time.Now()
+	stats.LastError = app.StringifyError(failure)
+	c.stats[s.command] = stats
+	c.mux.Unlock()
+
+	app.StatsCounterIncr("cleaner-reaped", int64(reaped))
+	app.StatsCounterIncr("cleaner-requeued", int64(requeued))
+
+	if reaped > 0 || requeued > 0 {
+		c.logger.WithData(app.KV{"command": s.command, "reaped": reaped, "requeued": requeued}).Info("cleaned tasks")
+	}
+}
+
 // Stop stops the process
 func (c *Cleaner) Stop() error {
-	close(c.stopChan)
+	c.once.Do(func() {
+		close(c.stopChan)
+	})
 
 	return nil
 }
 
-// Kill kills the process
+// Kill kills the process, cancelling any maintenance under way.
 func (c *Cleaner) Kill() error {
-	close(c.stopChan)
+	c.cancel()
 
-	return nil
+	return c.Stop()
 }
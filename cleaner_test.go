@@ -0,0 +1,111 @@
+package taskworker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/psimoesSsimoes/go-task-fanout/repositories/memory"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+func startCleaner(storage taskworker.TaskStorage, opts ...taskworker.CleanerOption) (*taskworker.Cleaner, func()) {
+	cleaner := taskworker.NewCleaner(storage, opts...)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cleaner.Start()
+	}()
+
+	return cleaner, func() {
+		Expect(cleaner.Stop()).To(Succeed())
+		Eventually(done).Should(Receive(BeNil()))
+	}
+}
+
+func TestCleanerReapsFinishedTasks(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	storage := memory.NewTaskStorage()
+
+	for _, command := range []string{"export", "import"} {
+		storage.Create(ctx, &taskworker.Task{TaskID: "1", Action: command})
+		task, _ := storage.Get(ctx, command, 0, time.Minute)
+		Expect(storage.Complete(ctx, task)).To(Succeed())
+	}
+
+	cleaner, stop := startCleaner(storage, taskworker.WithSchedule("export", 10*time.Millisecond, 50*time.Millisecond, 0))
+	defer stop()
+
+	Consistently(func() int64 {
+		return cleaner.Stats("export").Reaped
+	}, 30*time.Millisecond).Should(BeZero(), "tasks should be kept for the retention")
+
+	Eventually(func() int64 {
+		return cleaner.Stats("export").Reaped
+	}).Should(Equal(int64(1)))
+
+	Expect(storage.Result(ctx, "export", "1")).To(BeNil())
+	Expect(storage.Result(ctx, "import", "1")).ToNot(BeNil(), "unscheduled commands should be left alone")
+
+	stats := cleaner.Stats("export")
+	Expect(stats.Runs).To(BeNumerically(">=", 3), "the cleaner should run on its schedule")
+	Expect(stats.Requeued).To(BeZero())
+	Expect(stats.LastRunAt).To(BeTemporally("~", time.Now(), time.Second))
+	Expect(stats.LastError).To(BeEmpty())
+	Expect(cleaner.Stats("import")).To(Equal(taskworker.CleanerStats{}))
+}
+
+func TestCleanerRequeuesStaleTasks(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	storage := memory.NewTaskStorage()
+
+	storage.Create(ctx, &taskworker.Task{TaskID: "1", Action: "export"})
+	alive, _ := storage.Get(ctx, "export", 0, time.Hour)
+
+	storage.Create(ctx, &taskworker.Task{TaskID: "2", Action: "export"})
+	expired, _ := storage.Get(ctx, "export", 0, time.Millisecond)
+
+	cleaner, stop := startCleaner(storage, taskworker.WithSchedule("export", 10*time.Millisecond, 0, 50*time.Millisecond))
+	defer stop()
+
+	Eventually(func() int64 {
+		return cleaner.Stats("export").Requeued
+	}).Should(Equal(int64(1)), "tasks should be requeued once stale and their lease expired")
+	Consistently(func() int64 {
+		return cleaner.Stats("export").Requeued
+	}, 50*time.Millisecond).Should(Equal(int64(1)), "tasks whose lease is alive should be left to their handler")
+
+	Expect(storage.Complete(ctx, expired)).ToNot(Succeed(), "the expired claim should no longer finish the task")
+	Expect(storage.Complete(ctx, alive)).To(Succeed())
+
+	task, err := storage.Get(ctx, "export", 0, time.Hour)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(task).ToNot(BeNil(), "a requeued task should be claimable again")
+	Expect(task.TaskID).To(Equal("2"))
+	Expect(task.Attempts).To(Equal(1), "an expired lease should count an attempt")
+	Expect(task.LastError).To(Equal(taskworker.LeaseExpired))
+	Expect(storage.Complete(ctx, task)).To(Succeed())
+
+	Expect(cleaner.Stats("export").Reaped).To(BeZero(), "a retention of 0 should keep finished tasks")
+}
+
+func TestCleanerStopsOnKill(t *testing.T) {
+	RegisterTestingT(t)
+
+	cleaner := taskworker.NewCleaner(memory.NewTaskStorage(), taskworker.WithSchedule("export", time.Hour, time.Hour, 0))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cleaner.Start()
+	}()
+
+	Expect(cleaner.Kill()).To(Succeed())
+	Eventually(done).Should(Receive(BeNil()))
+	Expect(cleaner.Stop()).To(Succeed(), "stopping a killed cleaner should do nothing")
+	Expect(cleaner.Stats("export").Runs).To(BeZero())
+}
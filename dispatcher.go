@@ -1,31 +1,201 @@
 package taskworker
 
 import (
+	"context"
+	"encoding/json"
+	"time"
+
 	"github.com/pkg/errors"
 )
 
 // Dispatcher is a task dispatcher to a specific command
 type Dispatcher struct {
 	storage TaskStorage
+	topics  TopicRegistry
+}
+
+// DispatcherOption is the abstract functional-parameter type used for dispatcher configuration.
+type DispatcherOption func(*Dispatcher)
+
+// WithTopics allows you to configure the topic registry used by Publish. By default subscriptions are kept in memory, so
+// use a registry stored in the database to share them across processes.
+func WithTopics(topics TopicRegistry) DispatcherOption {
+	return func(d *Dispatcher) {
+		if topics != nil {
+			d.topics = topics
+		}
+	}
+}
+
+// DispatchOption is the abstract functional-parameter type used for configuring a dispatched task.
+type DispatchOption func(*Task)
+
+// WithPriority sets the priority of the task. Tasks with a higher priority are claimed first, tasks with the same
+// priority are claimed in creation order.
+func WithPriority(priority int) DispatchOption {
+	return func(t *Task) {
+		t.Priority = priority
+	}
+}
+
+// WithExecutionTimeout sets how long the task may be handled before it fails, overriding the receiver's task timeout.
+func WithExecutionTimeout(timeout time.Duration) DispatchOption {
+	return func(t *Task) {
+		t.Timeout = timeout
+	}
+}
+
+// WithParents sets the IDs of the tasks of the same workflow which must be completed before the task can be claimed.
+func WithParents(ids ...string) DispatchOption {
+	return func(t *Task) {
+		t.Parents = append(t.Parents, ids...)
+	}
+}
+
+// WithWorkflow adds the task to a workflow which was already submitted, so it can wait for the tasks of the workflow.
+func WithWorkflow(id string) DispatchOption {
+	return func(t *Task) {
+		t.WorkflowID = id
+	}
 }
 
 // NewDispatcher creates a new dispatcher
-func NewDispatcher(storage TaskStorage) *Dispatcher {
-	return &Dispatcher{
+func NewDispatcher(storage TaskStorage, opts ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{
 		storage: storage,
+		topics:  NewTopics(),
+	}
+
+	for _, opt := range opts {
+		opt(d)
 	}
+
+	return d
+}
+
+// Topics returns the topic registry used by Publish.
+func (d *Dispatcher) Topics() TopicRegistry {
+	return d.topics
+}
+
+// Process adds a task to be run as soon as possible. It returns false if the same task is already waiting or running,
+// so dispatches can be safely retried.
+func (d *Dispatcher) Process(command string, id string, data interface{}, opts ...DispatchOption) (bool, error) {
+	return d.ProcessAt(command, id, data, time.Time{}, opts...)
 }
 
-// Process adds a task
-func (d *Dispatcher) Process(command string, id string, data interface{}) error {
+// ProcessAt adds a task which is not run before runAt. It returns false if the same task is already waiting or running.
+func (d *Dispatcher) ProcessAt(command string, id string, data interface{}, runAt time.Time, opts ...DispatchOption) (bool, error) {
 	task := &Task{
 		TaskID: id,
 		Data:   data,
 		Action: command,
+		RunAt:  runAt,
+	}
+
+	for _, opt := range opts {
+		opt(task)
+	}
+
+	created, err := d.storage.Create(context.Background(), task)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create task")
 	}
+	return created, nil
+}
+
+// ProcessAfter adds a task which is not run before the delay has passed. It returns false if the same task is already
+// waiting or running.
+func (d *Dispatcher) ProcessAfter(command string, id string, data interface{}, delay time.Duration, opts ...DispatchOption) (bool, error) {
+	return d.ProcessAt(command, id, data, time.Now().Add(delay), opts...)
+}
 
-	if err := d.storage.Create(task); err != nil {
-		return errors.Wrap(err, "failed to create task")
+// Publish adds one task for each command subscribed to topic. Either all tasks are created or none is, except for the
+// subscribers which already have the same task waiting or running, so publishes can be safely retried. It returns the
+// number of subscribers a task was added for. Each task is retried and completed independently of the others.
+func (d *Dispatcher) Publish(topic string, id string, data interface{}, opts ...DispatchOption) (int, error) {
+	subscribers, err := d.topics.Subscribers(context.Background(), topic)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get topic subscribers")
+	}
+
+	if len(subscribers) == 0 {
+		return 0, errors.Errorf("no subscribers for topic %s", topic)
+	}
+
+	tasks := make([]*Task, 0, len(subscribers))
+	for _, command := range subscribers {
+		task := &Task{
+			TaskID: id,
+			Data:   data,
+			Action: command,
+		}
+
+		for _, opt := range opts {
+			opt(task)
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	created, err := d.storage.CreateBatch(context.Background(), tasks)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to publish task")
+	}
+	return created, nil
+}
+
+// ProcessGroup adds tasks as members of group. Once every member is finished the group callback is added with a
+// GroupSummary as its data.
+func (d *Dispatcher) ProcessGroup(group *Group, tasks []*Task) error {
+	if group.ID == "" || group.Callback == nil {
+		return errors.New("a group needs an id and a callback")
+	}
+
+	for _, task := range tasks {
+		task.GroupID = group.ID
+	}
+
+	if err := d.storage.CreateGroup(context.Background(), group, tasks); err != nil {
+		return errors.Wrap(err, "failed to create task group")
 	}
 	return nil
 }
+
+// Submit adds all tasks of a workflow, either all of them or none. Tasks are only claimed once their parents are
+// completed. It fails if a task with the same action and ID as one of the workflow is still waiting or running.
+func (d *Dispatcher) Submit(workflow *Workflow) error {
+	tasks, err := workflow.Tasks()
+	if err != nil {
+		return errors.Wrap(err, "invalid workflow")
+	}
+
+	if _, err := d.storage.CreateBatch(context.Background(), tasks); err != nil {
+		return errors.Wrap(err, "failed to submit workflow")
+	}
+	return nil
+}
+
+// Result decodes the result of the last completed task for command with the given ID into v. It returns false if no
+// such task is completed, or if it was already cleaned up. A task completed without a result leaves v untouched.
+func (d *Dispatcher) Result(command string, id string, v interface{}) (bool, error) {
+	task, err := d.storage.Result(context.Background(), command, id)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get task result")
+	}
+
+	if task == nil {
+		return false, nil
+	}
+
+	result, ok := task.Result.(json.RawMessage)
+	if !ok {
+		return true, nil
+	}
+
+	if err := json.Unmarshal(result, v); err != nil {
+		return true, errors.Wrap(err, "failed to decode task result")
+	}
+
+	return true, nil
+}
@@ -0,0 +1,95 @@
+package taskworker
+
+import (
+	"time"
+
+	"gitlab.com/mandalore/go-app/app"
+)
+
+const (
+	// ErrorPermanent is the code of errors returned by Permanent. Tasks failing with it are moved to the dead letter
+	// without being retried.
+	ErrorPermanent = 4220
+	// ErrorRetryAfter is the code of errors returned by RetryAfter. Tasks failing with it are retried after the given
+	// delay instead of the retry policy's backoff.
+	ErrorRetryAfter = 5030
+	// ErrorSnooze is the code of errors returned by Snooze.
+	ErrorSnooze = 3070
+	// ErrorDiscard is the code of errors returned by Discard.
+	ErrorDiscard = 2040
+)
+
+// Permanent wraps a handler error to tell the receiver the task will never succeed, so it is moved to the dead letter
+// right away.
+func Permanent(err error) error {
+	return app.NewError(ErrorPermanent, "permanent failure", err)
+}
+
+// RetryAfter wraps a handler error to tell the receiver to retry the task once delay passes. The attempt still counts
+// towards the retry policy's maximum.
+func RetryAfter(err error, delay time.Duration) error {
+	return app.NewErrorData(ErrorRetryAfter, "retry after "+delay.String(), err, map[string]interface{}{"delay": delay})
+}
+
+// Snooze tells the receiver to move the task back to the 'todo' state until the given time, without counting an attempt.
+// It suits tasks which poll for something, such as "check again in 5 minutes".
+func Snooze(until time.Time) error {
+	return app.NewErrorData(ErrorSnooze, "snoozed until "+until.Format(time.RFC3339), nil, map[string]interface{}{"until": until})
+}
+
+// Discard tells the receiver to drop the task, which is no longer needed, without moving it to the 'done' nor the
+// 'dead' state. Its group and the tasks waiting for it count it as completed.
+func Discard(reason string) error {
+	return app.NewError(ErrorDiscard, "discarded: "+reason, nil)
+}
+
+// snoozeUntil returns the time err, or any of its causes, was wrapped with by Snooze.
+func snoozeUntil(err error) (time.Time, bool) {
+	found, ok := find(err, ErrorSnooze).(app.ErrorData)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	until, ok := found.GetData()["until"].(time.Time)
+
+	return until, ok
+}
+
+// permanent tells if err, or any of its causes, was wrapped with Permanent.
+func permanent(err error) bool {
+	return find(err, ErrorPermanent) != nil
+}
+
+// retryDelay returns the delay err, or any of its causes, was wrapped with by RetryAfter.
+func retryDelay(err error) (time.Duration, bool) {
+	found, ok := find(err, ErrorRetryAfter).(app.ErrorData)
+	if !ok {
+		return 0, false
+	}
+
+	delay, ok := found.GetData()["delay"].(time.Duration)
+
+	return delay, ok
+}
+
+// find walks the causes of err, either app.Error, pkg/errors or fmt.Errorf %w ones, and returns the first app.Error
+// with the given code.
+func find(err error, code int) app.Error {
+	for err != nil {
+		switch cause := err.(type) {
+		case app.Error:
+			if cause.GetCode() == code {
+				return cause
+			}
+			err = cause.GetCause()
+		case interface{ Cause() error }:
+			err = cause.Cause()
+		case interface{ Unwrap() error }:
+			err = cause.Unwrap()
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
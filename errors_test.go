@@ -0,0 +1,40 @@
+package taskworker
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+	"gitlab.com/mandalore/go-app/app"
+)
+
+func TestPermanent(t *testing.T) {
+	RegisterTestingT(t)
+
+	err := Permanent(errors.New("invalid payload"))
+
+	Expect(permanent(err)).To(BeTrue())
+	Expect(permanent(errors.Wrap(err, "failed to handle task"))).To(BeTrue(), "wrapped errors should stay permanent")
+	Expect(permanent(errors.New("connection refused"))).To(BeFalse())
+	Expect(permanent(nil)).To(BeFalse())
+	Expect(app.StringifyError(err)).To(Equal("permanent failure; invalid payload"))
+}
+
+func TestRetryAfter(t *testing.T) {
+	RegisterTestingT(t)
+
+	err := RetryAfter(errors.New("rate limited"), time.Minute)
+
+	delay, ok := retryDelay(err)
+	Expect(ok).To(BeTrue())
+	Expect(delay).To(Equal(time.Minute))
+
+	delay, ok = retryDelay(app.NewError(app.ErrorUnexpected, "failed to handle task", errors.WithStack(err)))
+	Expect(ok).To(BeTrue(), "wrapped errors should keep their delay")
+	Expect(delay).To(Equal(time.Minute))
+
+	_, ok = retryDelay(errors.New("connection refused"))
+	Expect(ok).To(BeFalse())
+	Expect(permanent(err)).To(BeFalse())
+}
@@ -0,0 +1,26 @@
+package taskworker
+
+import (
+	"encoding/json"
+)
+
+// Group is a set of tasks which adds a callback task once all of its members are finished.
+type Group struct {
+	// ID identifies the group. Members reference it through Task.GroupID.
+	ID string
+	// Callback is the task added once the group is finished. Its data is wrapped in a GroupSummary.
+	Callback *Task
+	// CallbackOnDead adds the callback even if some members ended up dead. By default the callback is only added when
+	// every member completed.
+	CallbackOnDead bool
+}
+
+// GroupSummary is the data of a group callback task.
+type GroupSummary struct {
+	GroupID     string          `json:"group_id"`
+	Total       int             `json:"total"`
+	Completed   int             `json:"completed"`
+	Dead        int             `json:"dead"`
+	DeadTaskIDs []string        `json:"dead_task_ids,omitempty"`
+	Data        json.RawMessage `json:"data,omitempty"`
+}
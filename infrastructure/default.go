@@ -4,13 +4,12 @@ import (
 	"database/sql"
 	"fmt"
 
-	"gitlab.com/worten/dom/dom-integration-inbound/repositories/mysql"
-
 	"time"
 	//driver
-	_ "github.com/lib/pq"
+	_ "github.com/go-sql-driver/mysql"
 	"github.com/pkg/errors"
 	"github.com/psimoesSsimoes/go-task-fanout/interactors"
+	"github.com/psimoesSsimoes/go-task-fanout/repositories/mysql"
 	"gitlab.com/worten/dom/dom-integration-inbound/configurations"
 )
 
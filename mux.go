@@ -0,0 +1,155 @@
+package taskworker
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MuxOption is the abstract functional-parameter type used for mux configuration.
+type MuxOption func(*Mux)
+
+// Mux routes tasks to a handler by their action, so a single receiver can claim and handle several actions. Patterns
+// are either an exact action or a prefix ending in '*', like "orders.*". Exact patterns take precedence over prefixes,
+// and longer prefixes over shorter ones.
+type Mux struct {
+	mux      *sync.RWMutex
+	handlers map[string]ResultTaskHandler
+	fallback ResultTaskHandler
+	prefix   string
+	// scopes are the prefixes of the actions the mux claims besides its patterns
+	scopes []string
+}
+
+// WithFallback allows you to set the handler for tasks whose action starts with prefix but matches no pattern. The mux
+// claims the tasks of every action starting with prefix, so an empty prefix claims the tasks of every action.
+func WithFallback(prefix string, handler ContextTaskHandler) MuxOption {
+	return func(m *Mux) {
+		if handler != nil {
+			m.fallback = func(ctx context.Context, task *Task) (interface{}, error) {
+				return nil, handler(ctx, task)
+			}
+			m.prefix = prefix
+			m.scopes = append(m.scopes, prefix)
+		}
+	}
+}
+
+// WithUnknownToDeadLetter makes the mux claim the tasks of every action starting with prefix, so the receiver moves
+// those whose action matches no pattern to the dead letter. An empty prefix claims the tasks of every action.
+func WithUnknownToDeadLetter(prefix string) MuxOption {
+	return func(m *Mux) {
+		m.scopes = append(m.scopes, prefix)
+	}
+}
+
+// NewMux creates an empty mux.
+func NewMux(opts ...MuxOption) *Mux {
+	m := &Mux{
+		mux:      &sync.RWMutex{},
+		handlers: make(map[string]ResultTaskHandler),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Handle registers the handler for the actions matching pattern.
+func (m *Mux) Handle(pattern string, handler ContextTaskHandler) {
+	m.HandleResult(pattern, func(ctx context.Context, task *Task) (interface{}, error) {
+		return nil, handler(ctx, task)
+	})
+}
+
+// HandleResult registers a handler which returns the result of the task for the actions matching pattern.
+func (m *Mux) HandleResult(pattern string, handler ResultTaskHandler) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.handlers[pattern] = handler
+}
+
+// HandleFunc registers a handler which does not need a context for the actions matching pattern.
+func (m *Mux) HandleFunc(pattern string, handler TaskHandler) {
+	m.Handle(pattern, func(_ context.Context, task *Task) error {
+		return handler(task)
+	})
+}
+
+// Patterns returns the action patterns the mux claims tasks for.
+func (m *Mux) Patterns() []string {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	unique := make(map[string]bool, len(m.handlers)+len(m.scopes))
+	for pattern := range m.handlers {
+		unique[pattern] = true
+	}
+
+	for _, prefix := range m.scopes {
+		unique[prefix+"*"] = true
+	}
+
+	patterns := make([]string, 0, len(unique))
+	for pattern := range unique {
+		patterns = append(patterns, pattern)
+	}
+
+	sort.Strings(patterns)
+
+	return patterns
+}
+
+// Route returns the handler for action, or the fallback if no pattern matches it but the action starts with the prefix of
+// the fallback. It returns false if there is neither.
+func (m *Mux) Route(action string) (ContextTaskHandler, bool) {
+	handler, ok := m.route(action)
+	if !ok {
+		return nil, false
+	}
+
+	return func(ctx context.Context, task *Task) error {
+		_, err := handler(ctx, task)
+		return err
+	}, true
+}
+
+// route returns the handler for action, along with its result, or the fallback if no pattern matches it.
+func (m *Mux) route(action string) (ResultTaskHandler, bool) {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+
+	if handler, ok := m.handlers[action]; ok {
+		return handler, true
+	}
+
+	var best string
+	for pattern := range m.handlers {
+		if strings.HasSuffix(pattern, "*") && MatchAction(pattern, action) && len(pattern) > len(best) {
+			best = pattern
+		}
+	}
+
+	if best != "" {
+		return m.handlers[best], true
+	}
+
+	if m.fallback != nil && strings.HasPrefix(action, m.prefix) {
+		return m.fallback, true
+	}
+
+	return nil, false
+}
+
+// MatchAction reports whether action matches pattern. A pattern ending in '*' matches any action with that prefix.
+func MatchAction(pattern string, action string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(action, strings.TrimSuffix(pattern, "*"))
+	}
+
+	return pattern == action
+}
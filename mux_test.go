@@ -0,0 +1,66 @@
+package taskworker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestMuxRoute(t *testing.T) {
+	RegisterTestingT(t)
+
+	errExact, errShort, errLong := errors.New("exact"), errors.New("short"), errors.New("long")
+
+	m := NewMux()
+	m.HandleFunc("orders.created", func(*Task) error { return errExact })
+	m.HandleFunc("orders.*", func(*Task) error { return errShort })
+	m.HandleFunc("orders.items.*", func(*Task) error { return errLong })
+
+	handler, ok := m.Route("orders.created")
+	Expect(ok).To(BeTrue())
+	Expect(handler(context.Background(), &Task{})).To(Equal(errExact), "exact patterns should win")
+
+	handler, ok = m.Route("orders.items.added")
+	Expect(ok).To(BeTrue())
+	Expect(handler(context.Background(), &Task{})).To(Equal(errLong), "the longest prefix should win")
+
+	handler, ok = m.Route("orders.cancelled")
+	Expect(ok).To(BeTrue())
+	Expect(handler(context.Background(), &Task{})).To(Equal(errShort))
+
+	_, ok = m.Route("users.created")
+	Expect(ok).To(BeFalse(), "unknown actions should have no handler")
+
+	Expect(m.Patterns()).To(Equal([]string{"orders.*", "orders.created", "orders.items.*"}))
+}
+
+func TestMuxFallback(t *testing.T) {
+	RegisterTestingT(t)
+
+	m := NewMux(WithFallback("orders.", func(context.Context, *Task) error { return nil }))
+	m.HandleFunc("orders.created", func(*Task) error { return nil })
+	m.HandleFunc("users.*", func(*Task) error { return nil })
+
+	_, ok := m.Route("orders.cancelled")
+	Expect(ok).To(BeTrue(), "unknown actions with the prefix should go to the fallback")
+
+	_, ok = m.Route("payments.created")
+	Expect(ok).To(BeFalse(), "the fallback should only take actions with its prefix")
+
+	Expect(m.Patterns()).To(Equal([]string{"orders.*", "orders.created", "users.*"}), "a mux should only claim its prefix besides its patterns")
+}
+
+func TestMuxUnknownToDeadLetter(t *testing.T) {
+	RegisterTestingT(t)
+
+	m := NewMux(WithUnknownToDeadLetter("orders."))
+	m.HandleFunc("orders.created", func(*Task) error { return nil })
+
+	_, ok := m.Route("orders.cancelled")
+	Expect(ok).To(BeFalse(), "unknown actions should have no handler so they are moved to the dead letter")
+	Expect(m.Patterns()).To(Equal([]string{"orders.*", "orders.created"}))
+
+	Expect(NewMux(WithUnknownToDeadLetter("")).Patterns()).To(Equal([]string{"*"}), "an empty prefix should claim every action")
+}
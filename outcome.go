@@ -0,0 +1,49 @@
+package taskworker
+
+// Outcome is what happens to a task once its handler returns.
+type Outcome int
+
+const (
+	// OutcomeComplete moves the task to the 'done' state. It is the outcome of handlers returning nil.
+	OutcomeComplete Outcome = iota
+	// OutcomeRetry moves the task back to the 'todo' state, counting an attempt, after the retry policy's backoff or the
+	// delay given to RetryAfter. Once the retry policy is exhausted the task is moved to the 'dead' state instead. It is
+	// the outcome of any error not listed below.
+	OutcomeRetry
+	// OutcomeSnooze moves the task back to the 'todo' state until the time given to Snooze, without counting an attempt.
+	OutcomeSnooze
+	// OutcomeDiscard removes the task without moving it to the 'done' nor the 'dead' state, counting it as completed for
+	// its group and dependents. It is the outcome of Discard.
+	OutcomeDiscard
+	// OutcomeDeadLetter moves the task to the 'dead' state right away. It is the outcome of errors wrapped with Permanent.
+	OutcomeDeadLetter
+)
+
+var outcomeString = map[Outcome]string{
+	OutcomeComplete:   "COMPLETE",
+	OutcomeRetry:      "RETRY",
+	OutcomeSnooze:     "SNOOZE",
+	OutcomeDiscard:    "DISCARD",
+	OutcomeDeadLetter: "DEAD_LETTER",
+}
+
+// String returns the name of the outcome.
+func (o Outcome) String() string {
+	return outcomeString[o]
+}
+
+// OutcomeOf returns the outcome of a task whose handler returned err.
+func OutcomeOf(err error) Outcome {
+	switch {
+	case err == nil:
+		return OutcomeComplete
+	case find(err, ErrorSnooze) != nil:
+		return OutcomeSnooze
+	case find(err, ErrorDiscard) != nil:
+		return OutcomeDiscard
+	case permanent(err):
+		return OutcomeDeadLetter
+	default:
+		return OutcomeRetry
+	}
+}
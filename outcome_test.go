@@ -0,0 +1,29 @@
+package taskworker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+func TestOutcomeOf(t *testing.T) {
+	RegisterTestingT(t)
+
+	until := time.Now().Add(5 * time.Minute)
+
+	Expect(OutcomeOf(nil)).To(Equal(OutcomeComplete))
+	Expect(OutcomeOf(errors.New("boom"))).To(Equal(OutcomeRetry))
+	Expect(OutcomeOf(RetryAfter(errors.New("boom"), time.Minute))).To(Equal(OutcomeRetry))
+	Expect(OutcomeOf(Snooze(until))).To(Equal(OutcomeSnooze))
+	Expect(OutcomeOf(errors.Wrap(Discard("stale"), "failed to handle task"))).To(Equal(OutcomeDiscard))
+	Expect(OutcomeOf(Permanent(errors.New("boom")))).To(Equal(OutcomeDeadLetter))
+	Expect(OutcomeOf(fmt.Errorf("failed to handle task: %w", Permanent(errors.New("boom"))))).To(Equal(OutcomeDeadLetter))
+
+	snoozed, ok := snoozeUntil(Snooze(until))
+	Expect(ok).To(BeTrue())
+	Expect(snoozed).To(Equal(until))
+	Expect(OutcomeSnooze.String()).To(Equal("SNOOZE"))
+}
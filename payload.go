@@ -0,0 +1,78 @@
+package taskworker
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Payloads is a registry of the Go type of the data of each action. A receiver configured with it decodes the data of
+// a task before calling the handler, so the handler receives a pointer to the registered type.
+type Payloads struct {
+	mux   *sync.RWMutex
+	types map[string]reflect.Type
+}
+
+// NewPayloads creates an empty payload registry.
+func NewPayloads() *Payloads {
+	return &Payloads{
+		mux:   &sync.RWMutex{},
+		types: make(map[string]reflect.Type),
+	}
+}
+
+// Register sets the type of the data of action from a sample value, for example Register("send-email", Email{}).
+// Handlers of the action then receive a *Email as the task data.
+func (p *Payloads) Register(action string, sample interface{}) {
+	t := reflect.TypeOf(sample)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if t == nil {
+		delete(p.types, action)
+
+		return
+	}
+
+	p.types[action] = t
+}
+
+// Decode replaces the data of task with a pointer to a value of the type registered for its action. Tasks of actions
+// without a registered type are left untouched.
+func (p *Payloads) Decode(task *Task) error {
+	p.mux.RLock()
+	t, ok := p.types[task.Action]
+	p.mux.RUnlock()
+
+	if !ok {
+		return nil
+	}
+
+	var raw []byte
+	switch data := task.Data.(type) {
+	case []byte:
+		raw = data
+	case string:
+		raw = []byte(data)
+	default:
+		var err error
+		if raw, err = json.Marshal(data); err != nil {
+			return errors.Wrapf(err, "failed to encode the data of action %s", task.Action)
+		}
+	}
+
+	value := reflect.New(t)
+	if err := json.Unmarshal(raw, value.Interface()); err != nil {
+		return errors.Wrapf(err, "failed to decode the data of action %s", task.Action)
+	}
+
+	task.Data = value.Interface()
+
+	return nil
+}
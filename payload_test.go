@@ -0,0 +1,29 @@
+package taskworker
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+type testPayload struct {
+	OfferID string `json:"offer_id"`
+}
+
+func TestPayloadsDecode(t *testing.T) {
+	RegisterTestingT(t)
+
+	payloads := NewPayloads()
+	payloads.Register("offer", testPayload{})
+
+	task := &Task{Action: "offer", Data: []byte(`{"offer_id": "123"}`)}
+	Expect(payloads.Decode(task)).To(Succeed())
+	Expect(task.Data).To(Equal(&testPayload{OfferID: "123"}))
+
+	task = &Task{Action: "offer", Data: []byte(`{"offer_id": 123}`)}
+	Expect(payloads.Decode(task)).ToNot(Succeed(), "data not matching the registered type should fail")
+
+	task = &Task{Action: "other", Data: []byte(`{}`)}
+	Expect(payloads.Decode(task)).To(Succeed())
+	Expect(task.Data).To(Equal([]byte(`{}`)), "unregistered actions should be left untouched")
+}
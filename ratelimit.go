@@ -0,0 +1,72 @@
+package taskworker
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RateLimiter paces how often tasks are handled.
+type RateLimiter interface {
+	// Wait blocks until another task may be handled. It returns an error if ctx is done first.
+	Wait(ctx context.Context) error
+}
+
+// TokenBucket is a RateLimiter which allows perSecond tasks on average and up to burst tasks at once.
+type TokenBucket struct {
+	mux    *sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a token bucket which starts full. A burst lower than 1 is raised to 1, while a rate which is not
+// positive is rejected.
+func NewTokenBucket(perSecond float64, burst int) (*TokenBucket, error) {
+	if perSecond <= 0 {
+		return nil, errors.Errorf("rate limit must be positive, got %v", perSecond)
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucket{
+		mux:    &sync.Mutex{},
+		rate:   perSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}, nil
+}
+
+// Wait blocks until a token is available and takes it. It returns an error if ctx is done first.
+func (b *TokenBucket) Wait(ctx context.Context) error {
+	for {
+		b.mux.Lock()
+
+		now := time.Now()
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mux.Unlock()
+
+			return nil
+		}
+
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mux.Unlock()
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package taskworker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestTokenBucketBurst(t *testing.T) {
+	RegisterTestingT(t)
+
+	bucket, err := NewTokenBucket(10, 3)
+	Expect(err).ToNot(HaveOccurred())
+	start := time.Now()
+
+	for i := 0; i < 3; i++ {
+		Expect(bucket.Wait(context.Background())).To(Succeed())
+	}
+	Expect(time.Since(start)).To(BeNumerically("<", 50*time.Millisecond), "a full bucket should allow a burst")
+
+	Expect(bucket.Wait(context.Background())).To(Succeed())
+	Expect(time.Since(start)).To(BeNumerically(">=", 90*time.Millisecond), "an empty bucket should pace tasks")
+}
+
+func TestTokenBucketCancel(t *testing.T) {
+	RegisterTestingT(t)
+
+	bucket, err := NewTokenBucket(0.1, 1)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(bucket.Wait(context.Background())).To(Succeed())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	Expect(bucket.Wait(ctx)).ToNot(Succeed(), "waiting should stop once the context is done")
+}
+
+func TestTokenBucketRejectsInvalidRate(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, err := NewTokenBucket(0, 1)
+	Expect(err).To(HaveOccurred())
+
+	_, err = NewTokenBucket(-1, 1)
+	Expect(err).To(HaveOccurred())
+}
@@ -1,7 +1,10 @@
 package taskworker
 
 import (
+	"context"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"fmt"
@@ -43,24 +46,51 @@ var workerStateString = map[int]string{
 
 // TaskHandler is the function signature for any function capable of handling a task.
 // Both the task TaskID and task data are provided to the handler. The task TaskID can and
-// should be used to identify task types by the way of a prefix or bit mask.
+// should be used to identify task types by the way of a prefix or bit mask. A returned error fails the task, which is
+// retried according to the retry policy unless the error is wrapped with Permanent or RetryAfter. Handlers may also
+// return Snooze or Discard, see Outcome.
 type TaskHandler func(*Task) error
 
+// ContextTaskHandler is a TaskHandler which also receives a context. The context is cancelled when the receiver is
+// killed or when the task exceeds its timeout, so it should be passed down to any blocking call.
+type ContextTaskHandler func(context.Context, *Task) error
+
+// ResultTaskHandler is a ContextTaskHandler which also returns the output of the task. The result of a completed task
+// is stored, as JSON, alongside it, see Dispatcher.Result.
+type ResultTaskHandler func(context.Context, *Task) (interface{}, error)
+
 // ReceiverOption is the abstract functional-parameter type used for worker configuration.
 type ReceiverOption func(*Receiver)
 
 // Receiver is the task e handler
 type Receiver struct {
-	mux       *sync.Mutex
-	state     int
-	batchSize int
-	age       time.Duration
-	tick      time.Duration
-	control   chan bool
-	command   string
-	storage   TaskStorage
-	handler   TaskHandler
-	logger    logger.Logger
+	mux         *sync.Mutex
+	state       int
+	batchSize   int
+	concurrency int
+	inFlight    int64
+	panics      int64
+	slots       chan struct{}
+	age         time.Duration
+	tick        time.Duration
+	taskTimeout time.Duration
+	drain       time.Duration
+	lease       time.Duration
+	reclaim     time.Duration
+	retry       RetryPolicy
+	control     chan bool
+	stopping    chan bool
+	ctx         context.Context
+	cancel      context.CancelFunc
+	command     string
+	storage     TaskStorage
+	notifier    TaskNotifier
+	payloads    *Payloads
+	router      *Mux
+	limiter     RateLimiter
+	handler     ResultTaskHandler
+	held        *leases
+	logger      logger.Logger
 }
 
 // WithLogger allows you to configure the logger.
@@ -83,11 +113,44 @@ func WithTick(tick int) ReceiverOption {
 
 // WithWorkHandler this will configure the handler for each work job. This is a required option (insanity!).
 func WithWorkHandler(f TaskHandler) ReceiverOption {
+	return func(r *Receiver) {
+		if f != nil {
+			r.handler = func(_ context.Context, task *Task) (interface{}, error) {
+				return nil, f(task)
+			}
+		}
+	}
+}
+
+// WithContextHandler configures a context aware handler for each work job. Use it instead of WithWorkHandler.
+func WithContextHandler(f ContextTaskHandler) ReceiverOption {
+	return func(r *Receiver) {
+		if f != nil {
+			r.handler = func(ctx context.Context, task *Task) (interface{}, error) {
+				return nil, f(ctx, task)
+			}
+		}
+	}
+}
+
+// WithResultHandler configures a context aware handler for each work job which returns the result of the task. Use it
+// instead of WithWorkHandler.
+func WithResultHandler(f ResultTaskHandler) ReceiverOption {
 	return func(r *Receiver) {
 		r.handler = f
 	}
 }
 
+// WithTaskTimeout allows you to set how long a handler may run before its context is cancelled and the task fails with a
+// timeout. Tasks dispatched with an execution timeout use their own. A value of 0 means no timeout.
+func WithTaskTimeout(timeout time.Duration) ReceiverOption {
+	return func(r *Receiver) {
+		if timeout >= 0 {
+			r.taskTimeout = timeout
+		}
+	}
+}
+
 // WithBatchSize allows you to create a configuration for the max size of the tasks. Once the is has no tasks, receiver will ask for more.
 func WithBatchSize(size int) ReceiverOption {
 	return func(r *Receiver) {
@@ -97,7 +160,95 @@ func WithBatchSize(size int) ReceiverOption {
 	}
 }
 
-// WithTaskAge allows you to set the task age allowed to be processed
+// WithConcurrency allows you to configure how many tasks of a batch are handled at the same time. Defaults to 1, which handles tasks one after the other.
+func WithConcurrency(n int) ReceiverOption {
+	return func(r *Receiver) {
+		if n > 0 {
+			r.concurrency = n
+		}
+	}
+}
+
+// WithDrainTimeout allows you to set how long Stop waits for in flight tasks to finish before cancelling them.
+func WithDrainTimeout(timeout time.Duration) ReceiverOption {
+	return func(r *Receiver) {
+		if timeout >= 0 {
+			r.drain = timeout
+		}
+	}
+}
+
+// WithLease allows you to set for how long a claimed task is reserved to this receiver. The lease of every claimed task
+// is extended periodically until it is concluded or released, whether it is being handled or still waiting for a slot,
+// so it only expires if the receiver dies. Expired tasks are moved back to 'todo'.
+func WithLease(lease time.Duration) ReceiverOption {
+	return func(r *Receiver) {
+		if lease > 0 {
+			r.lease = lease
+		}
+	}
+}
+
+// WithReclaimInterval allows you to set how often the receiver looks for tasks with an expired lease. Defaults to the lease duration.
+func WithReclaimInterval(interval time.Duration) ReceiverOption {
+	return func(r *Receiver) {
+		if interval > 0 {
+			r.reclaim = interval
+		}
+	}
+}
+
+// WithRetryPolicy allows you to configure how failed tasks are retried. See DefaultRetryPolicy for the defaults.
+func WithRetryPolicy(policy RetryPolicy) ReceiverOption {
+	return func(r *Receiver) {
+		r.retry = policy
+	}
+}
+
+// WithNotifier allows the receiver to wake up as soon as tasks become claimable instead of waiting for the next tick.
+// The tick is still used as a fallback, so it can be configured to a much longer interval.
+func WithNotifier(notifier TaskNotifier) ReceiverOption {
+	return func(r *Receiver) {
+		r.notifier = notifier
+	}
+}
+
+// WithMux allows the receiver to claim the tasks of every action matching the patterns of the mux and route each of
+// them to its handler. Use it instead of WithWorkHandler. Tasks whose action has no handler go to the fallback of the
+// mux if they start with its prefix, and are moved to the dead letter otherwise.
+func WithMux(mux *Mux) ReceiverOption {
+	return func(r *Receiver) {
+		r.router = mux
+	}
+}
+
+// WithRateLimit allows you to handle at most perSecond tasks on average, with bursts of up to burst tasks, across all
+// batches of the receiver. A rate which is not positive leaves the receiver unlimited.
+func WithRateLimit(perSecond float64, burst int) ReceiverOption {
+	return func(r *Receiver) {
+		if limiter, err := NewTokenBucket(perSecond, burst); err == nil {
+			r.limiter = limiter
+		}
+	}
+}
+
+// WithRateLimiter allows you to pace the receiver with any RateLimiter, for example one shared by several replicas.
+func WithRateLimiter(limiter RateLimiter) ReceiverOption {
+	return func(r *Receiver) {
+		r.limiter = limiter
+	}
+}
+
+// WithPayloads allows the receiver to decode the data of each task into the type registered for its action before
+// calling the handler. Tasks whose data cannot be decoded are moved to the dead letter without being retried.
+func WithPayloads(payloads *Payloads) ReceiverOption {
+	return func(r *Receiver) {
+		r.payloads = payloads
+	}
+}
+
+// WithTaskAge allows you to set how long after being created a task may be processed. Defaults to 0, so tasks are only
+// held back by the time they were dispatched to run at.
 func WithTaskAge(age time.Duration) ReceiverOption {
 	return func(r *Receiver) {
 		r.age = age
@@ -107,24 +258,41 @@ func WithTaskAge(age time.Duration) ReceiverOption {
 // NewReceiver creates a new receiver
 func NewReceiver(storage TaskStorage, command string, opts ...ReceiverOption) *Receiver {
 	r := &Receiver{
-		storage:   storage,
-		command:   command,
-		state:     StateReady,
-		tick:      time.Second,
-		batchSize: 1000,
-		age:       time.Duration(24 * time.Hour),
-		control:   make(chan bool),
-		mux:       &sync.Mutex{},
-		logger:    logger.SpawnMute(),
+		storage:     storage,
+		command:     command,
+		state:       StateReady,
+		tick:        time.Second,
+		batchSize:   1000,
+		concurrency: 1,
+		drain:       30 * time.Second,
+		lease:       5 * time.Minute,
+		retry:       DefaultRetryPolicy(),
+		control:     make(chan bool),
+		stopping:    make(chan bool),
+		mux:         &sync.Mutex{},
+		held:        &leases{tasks: make(map[int]*Task)},
+		logger:      logger.SpawnMute(),
 	}
 
 	for _, opt := range opts {
 		opt(r)
 	}
 
+	r.slots = make(chan struct{}, r.concurrency)
+	if r.reclaim == 0 {
+		r.reclaim = r.lease
+	}
+
 	return r
 }
 
+func (r *Receiver) getState() int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return r.state
+}
+
 func (r *Receiver) setState(state int) error {
 	r.mux.Lock()
 
@@ -155,7 +323,7 @@ func (r *Receiver) setState(state int) error {
 		}
 	case StateStopping:
 		switch state {
-		case StateStopped:
+		case StateStopped, StateDying:
 			validTransition = true
 		}
 	case StateStopped:
@@ -184,7 +352,7 @@ func (r *Receiver) setState(state int) error {
 
 // Start starts the process.
 func (r *Receiver) Start() error {
-	if r.handler == nil {
+	if r.handler == nil && r.router == nil {
 		return errors.New("no task handler was set")
 	}
 
@@ -192,85 +360,532 @@ func (r *Receiver) Start() error {
 		return err
 	}
 
+	// each run has its own context, since draining or killing the previous run cancelled it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r.mux.Lock()
+	r.ctx, r.cancel = ctx, cancel
+	r.mux.Unlock()
+
+	reclaimerDone := make(chan bool)
+	defer close(reclaimerDone)
+	go r.reclaimer(reclaimerDone)
+
+	var wake <-chan bool
+	if r.notifier != nil {
+		var unsubscribe func()
+		wake, unsubscribe = r.notifier.Subscribe(r.actions()...)
+		defer unsubscribe()
+	}
+
 	for {
 		select {
 		case <-time.After(r.tick):
-			if r.state == StateStopping {
-				return nil
+			r.poll()
+		case <-wake:
+			r.poll()
+		case <-r.stopping:
+			if r.getState() == StateDying {
+				return r.setState(StateDead)
 			}
 
-			if err := r.setState(StateProcessing); err != nil {
-				r.logger.WithData(app.KV{"cause": app.StringifyError(err)}).Info("failed to set state")
+			r.mux.Lock()
+			r.stopping = make(chan bool)
+			r.mux.Unlock()
 
-				continue
+			return r.setState(StateStopped)
+		case <-r.control:
+			if r.getState() == StateDying {
+				return r.setState(StateDead)
 			}
+		}
+	}
+}
 
-			tasks, err := r.storage.GetBatch(r.command, r.age, r.batchSize)
-			if err != nil {
-				r.logger.WithData(app.KV{"cause": app.StringifyError(err)}).Warn("failed to get task batch")
-				if err := r.setState(StateRunning); err != nil {
-					r.logger.WithData(app.KV{"cause": app.StringifyError(err)}).Info("failed to set state")
+// poll claims a batch of tasks and handles them.
+func (r *Receiver) poll() {
+	if r.halting() {
+		return
+	}
 
-					continue
-				}
+	if err := r.setState(StateProcessing); err != nil {
+		r.logger.WithData(app.KV{"cause": app.StringifyError(err)}).Info("failed to set state")
 
-				continue
-			}
+		return
+	}
+
+	tasks, err := r.storage.GetBatch(r.ctx, r.actions(), r.age, r.lease, r.batchSize)
+	if err != nil {
+		r.logger.WithData(app.KV{"cause": app.StringifyError(err)}).Warn("failed to get task batch")
+		if r.halting() {
+			return
+		}
+
+		if err := r.setState(StateRunning); err != nil {
+			r.logger.WithData(app.KV{"cause": app.StringifyError(err)}).Info("failed to set state")
+		}
+
+		return
+	}
+
+	r.processBatch(tasks)
+
+	if r.halting() {
+		return
+	}
+
+	if err := r.setState(StateRunning); err != nil {
+		r.logger.WithData(app.KV{"cause": app.StringifyError(err)}).Info("failed to set state")
+	}
+}
 
-			r.processBatch(tasks)
+// halting tells if the receiver was stopped or killed, so it must not claim nor start any more tasks.
+func (r *Receiver) halting() bool {
+	state := r.getState()
 
-			if err := r.setState(StateRunning); err != nil {
-				r.logger.WithData(app.KV{"cause": app.StringifyError(err)}).Info("failed to set state")
+	return state == StateStopping || state == StateDying
+}
+
+// actions returns the action patterns the receiver claims tasks for.
+func (r *Receiver) actions() []string {
+	if r.router != nil {
+		return r.router.Patterns()
+	}
+
+	return []string{r.command}
+}
+
+// reclaimer periodically moves tasks whose lease expired back to the 'todo' state until done is closed.
+func (r *Receiver) reclaimer(done chan bool) {
+	for {
+		select {
+		case <-time.After(r.reclaim):
+			n, err := r.storage.Reclaim(r.ctx, r.actions())
+			if err != nil {
+				r.logger.WithData(app.KV{"cause": app.StringifyError(err)}).Warn("failed to reclaim expired tasks")
 
 				continue
 			}
-		case <-r.control:
-			if r.state == StateDying {
-				r.setState(StateDead)
 
-				return nil
+			if n > 0 {
+				r.logger.WithData(app.KV{"tasks": n}).Info("reclaimed tasks with expired lease")
 			}
+		case <-done:
+			return
 		}
 	}
 }
 
-func (r *Receiver) processBatch(tasks []*Task) {
+// leases is the set of claimed tasks which were neither concluded nor released yet.
+type leases struct {
+	mux   sync.Mutex
+	tasks map[int]*Task
+}
+
+func (l *leases) hold(tasks ...*Task) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
 	for _, task := range tasks {
-		if err := r.processTask(task); err != nil {
-			r.logger.WithData(app.KV{"task_id": task.TaskID}).Info("failed to process task")
+		l.tasks[task.ID] = task
+	}
+}
+
+func (l *leases) drop(tasks ...*Task) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	for _, task := range tasks {
+		delete(l.tasks, task.ID)
+	}
+}
+
+func (l *leases) list() []*Task {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	tasks := make([]*Task, 0, len(l.tasks))
+	for _, task := range l.tasks {
+		tasks = append(tasks, task)
+	}
+
+	return tasks
+}
+
+// heartbeat keeps extending the lease of every held task until the returned function is called. A task whose lease
+// can't be extended is dropped, since it may have been reclaimed by now.
+func (r *Receiver) heartbeat() func() {
+	done := make(chan bool)
+
+	go func() {
+		ticker := time.NewTicker(r.lease / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				for _, task := range r.held.list() {
+					if err := r.storage.Extend(r.ctx, task, r.lease); err != nil {
+						r.logger.WithData(app.KV{"task_id": task.TaskID, "cause": app.StringifyError(err)}).Warn("failed to extend task lease")
+						r.held.drop(task)
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// stopped returns the channel which is closed once the current run is stopped.
+func (r *Receiver) stopped() <-chan bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+
+	return r.stopping
+}
+
+// InFlight returns the number of tasks currently being handled.
+func (r *Receiver) InFlight() int {
+	return int(atomic.LoadInt64(&r.inFlight))
+}
+
+// Panics returns the number of handler panics recovered since the receiver was created.
+func (r *Receiver) Panics() int {
+	return int(atomic.LoadInt64(&r.panics))
+}
+
+// processBatch handles the tasks on a pool of at most r.concurrency goroutines and returns once all of them are done.
+// The leases of the whole batch are kept alive meanwhile. If the receiver is stopped, tasks which did not start yet
+// are released back to the 'todo' state.
+func (r *Receiver) processBatch(tasks []*Task) {
+	r.held.hold(tasks...)
+	defer r.held.drop(tasks...)
+
+	stop := r.heartbeat()
+	defer stop()
+
+	wg := &sync.WaitGroup{}
+
+	for i, task := range tasks {
+		if !r.throttle() {
+			if r.ctx.Err() == nil {
+				r.release(tasks[i:])
+				r.wait(wg)
+			}
+
+			return
+		}
+
+		select {
+		case r.slots <- struct{}{}:
+		case <-r.stopped():
+			r.release(tasks[i:])
+			r.wait(wg)
+
+			return
+		case <-r.ctx.Done():
+			return
+		}
+
+		// a free slot and a stop may be ready at once, so no task starts after Stop whichever one was picked
+		select {
+		case <-r.stopped():
+			<-r.slots
+			r.release(tasks[i:])
+			r.wait(wg)
 
+			return
+		default:
 		}
+
+		atomic.AddInt64(&r.inFlight, 1)
+		wg.Add(1)
+
+		go func(task *Task) {
+			defer func() {
+				atomic.AddInt64(&r.inFlight, -1)
+				<-r.slots
+				wg.Done()
+			}()
+
+			if err := r.processTask(task); err != nil {
+				r.logger.WithData(app.KV{"task_id": task.TaskID, "cause": app.StringifyError(err)}).Info("failed to process task")
+			}
+		}(task)
 	}
+
+	r.wait(wg)
+}
+
+// throttle blocks until the rate limiter allows handling another task. It returns false if the receiver is stopped or
+// killed meanwhile, or if the rate limiter fails.
+func (r *Receiver) throttle() bool {
+	if r.limiter == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-r.stopped():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := r.limiter.Wait(ctx); err != nil {
+		if ctx.Err() == nil {
+			r.logger.WithData(app.KV{"cause": app.StringifyError(err)}).Error("failed to wait for the rate limiter")
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// wait blocks until all in flight tasks are done. Once the receiver is stopping it waits at most the drain timeout,
+// after which the handlers' context is cancelled and their tasks are left for reclaiming. Tasks stop waiting for a
+// cancelled handler right away, so it still returns once they did.
+func (r *Receiver) wait(wg *sync.WaitGroup) {
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-r.ctx.Done():
+		return
+	case <-r.stopped():
+	}
+
+	select {
+	case <-done:
+	case <-r.ctx.Done():
+	case <-time.After(r.drain):
+		r.logger.WithData(app.KV{"in_flight": r.InFlight()}).Warn("drain timeout exceeded, cancelling in flight tasks")
+		r.cancel()
+		<-done
+	}
+}
+
+// release returns claimed tasks which were never handled back to the 'todo' state.
+func (r *Receiver) release(tasks []*Task) {
+	if len(tasks) == 0 {
+		return
+	}
+
+	r.held.drop(tasks...)
+
+	if err := r.storage.Release(r.ctx, tasks); err != nil {
+		r.logger.WithData(app.KV{"tasks": len(tasks), "cause": app.StringifyError(err)}).Error("failed to release unstarted tasks")
+
+		return
+	}
+
+	r.logger.WithData(app.KV{"tasks": len(tasks)}).Info("released unstarted tasks")
 }
 
 func (r *Receiver) processTask(task *Task) error {
-	if err := r.handler(task); err != nil {
-		if err := r.storage.Fail(task, err.Error()); err != nil {
-			return errors.Wrap(err, "failed to mark task as failed")
+	// a task whose lease expired too many times, like one crashing its receivers, is not handled again
+	if r.retry.For(task.Action).Exhausted(task.Attempts) {
+		r.logger.WithData(app.KV{"task_id": task.TaskID, "attempts": task.Attempts}).Warn("task exhausted its retries")
+
+		return r.bury(task, task.LastError)
+	}
+
+	if r.payloads != nil {
+		if err := r.payloads.Decode(task); err != nil {
+			return r.bury(task, app.StringifyError(err))
+		}
+	}
+
+	handler := r.handler
+	if r.router != nil {
+		var ok bool
+		if handler, ok = r.router.route(task.Action); !ok {
+			return r.bury(task, "no handler for action "+task.Action)
 		}
 	}
 
-	if err := r.storage.Complete(task); err != nil {
-		return errors.Wrap(err, "failed to mark task as completed")
+	timeout := r.taskTimeout
+	if task.Timeout > 0 {
+		timeout = task.Timeout
+	}
+
+	ctx := r.ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(r.ctx, timeout)
+		defer cancel()
+	}
+
+	result, err := r.run(ctx, handler, task)
+
+	if r.ctx.Err() != nil {
+		// the receiver was killed, so the task is left for reclaiming
+		return errors.Wrap(r.ctx.Err(), "task was interrupted")
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.Errorf("task timed out after %s", timeout)
+	}
+
+	task.Result = result
+
+	return r.conclude(task, err)
+}
+
+// conclude applies the outcome of the error returned by the handler of a task.
+func (r *Receiver) conclude(task *Task, err error) error {
+	r.held.drop(task)
+
+	switch OutcomeOf(err) {
+	case OutcomeComplete:
+		if err := r.storage.Complete(r.ctx, task); err != nil {
+			return errors.Wrap(err, "failed to mark task as completed")
+		}
+	case OutcomeSnooze:
+		until, _ := snoozeUntil(err)
+		if err := r.storage.Snooze(r.ctx, task, until); err != nil {
+			return errors.Wrap(err, "failed to snooze task")
+		}
+	case OutcomeDiscard:
+		r.logger.WithData(app.KV{"task_id": task.TaskID, "cause": app.StringifyError(err)}).Info("discarding task")
+
+		if err := r.storage.Discard(r.ctx, task); err != nil {
+			return errors.Wrap(err, "failed to discard task")
+		}
+	case OutcomeDeadLetter:
+		return r.bury(task, app.StringifyError(err))
+	default:
+		return r.fail(task, err)
+	}
+
+	return nil
+}
+
+// run calls the handler and returns what it did, or the error of ctx once it is done. A handler which ignores its
+// context still holds its slot and lease until it returns, so the task never runs twice at once, unless the receiver is
+// killed or exceeds its drain timeout meanwhile. A panic in the handler is recovered and returned as an error holding
+// the panic value and stack trace.
+func (r *Receiver) run(ctx context.Context, handler ResultTaskHandler, task *Task) (interface{}, error) {
+	type outcome struct {
+		result interface{}
+		err    error
+	}
+
+	done := make(chan outcome, 1)
+
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				atomic.AddInt64(&r.panics, 1)
+				r.logger.WithData(app.KV{"task_id": task.TaskID, "panic": fmt.Sprint(v)}).Error("recovered from task handler panic")
+
+				done <- outcome{err: errors.Errorf("task handler panicked: %v\n%s", v, debug.Stack())}
+			}
+		}()
+
+		result, err := handler(ctx, task)
+		done <- outcome{result, err}
+	}()
+
+	select {
+	case o := <-done:
+		return o.result, o.err
+	case <-ctx.Done():
+	}
+
+	select {
+	case <-done:
+	case <-r.ctx.Done():
+	}
+
+	return nil, ctx.Err()
+}
+
+// fail schedules a failed task to be retried according to the retry policy, or moves it to the dead letter once it is
+// exhausted. Errors wrapped with RetryAfter are retried after their own delay.
+func (r *Receiver) fail(task *Task, err error) error {
+	reason := app.StringifyError(err)
+	policy := r.retry.For(task.Action)
+	attempts := task.Attempts + 1
+
+	if policy.Exhausted(attempts) {
+		r.logger.WithData(app.KV{"task_id": task.TaskID, "attempts": attempts}).Warn("task exhausted its retries")
+
+		return r.bury(task, reason)
+	}
+
+	delay, ok := retryDelay(err)
+	if !ok {
+		delay = policy.Backoff(attempts)
+	}
+
+	if err := r.storage.Fail(r.ctx, task, reason, delay); err != nil {
+		return errors.Wrap(err, "failed to mark task as failed")
 	}
 
 	return nil
 }
 
-// Stop stops the process
+// bury moves a task which will never succeed to the dead letter.
+func (r *Receiver) bury(task *Task, reason string) error {
+	r.held.drop(task)
+	r.logger.WithData(app.KV{"task_id": task.TaskID, "cause": reason}).Error("moving task to the dead letter")
+
+	if err := r.storage.Bury(r.ctx, task, reason); err != nil {
+		return errors.Wrap(err, "failed to mark task as dead")
+	}
+
+	return nil
+}
+
+// Stop stops the process. No more tasks are claimed, in flight tasks are given up to the drain timeout to finish and
+// claimed tasks which did not start yet are released back to the 'todo' state. The receiver only reaches StateStopped
+// once this is done.
 func (r *Receiver) Stop() error {
 	if err := r.setState(StateStopping); err != nil {
 		return err
 	}
-	if r.state == StateProcessing {
-		r.logger.Warn("stopping worker with tasks in queue")
+	if r.InFlight() > 0 {
+		r.logger.WithData(app.KV{"in_flight": r.InFlight()}).Warn("stopping worker with tasks in flight")
 	}
 
+	r.mux.Lock()
+	close(r.stopping)
+	r.mux.Unlock()
+
 	return nil
 }
 
-// Kill kills the process
+// Kill kills the process, cancelling the context of any running handler. A receiver which is draining after Stop can
+// still be killed, which stops waiting for its in flight tasks.
 func (r *Receiver) Kill() error {
+	if err := r.setState(StateDying); err != nil {
+		return err
+	}
+
+	r.mux.Lock()
+	cancel := r.cancel
+	r.mux.Unlock()
+
+	// a receiver stopped before it ever started has no handlers to cancel
+	if cancel != nil {
+		cancel()
+	}
 	close(r.control)
 
 	return nil
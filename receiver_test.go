@@ -0,0 +1,551 @@
+package taskworker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/psimoesSsimoes/go-task-fanout/repositories/memory"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+func TestReceiverHandlesTasks(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage := memory.NewTaskStorage()
+	handled := make(chan string, 2)
+
+	_, stop := startReceiver(storage, "cmd",
+		taskworker.WithWorkHandler(func(task *taskworker.Task) error {
+			handled <- task.TaskID
+			if task.TaskID == "bad" {
+				return errors.New("boom")
+			}
+
+			return nil
+		}),
+		taskworker.WithRetryPolicy(taskworker.RetryPolicy{MaxAttempts: 1}),
+	)
+	defer stop()
+
+	dispatcher := taskworker.NewDispatcher(storage)
+	dispatcher.Process("cmd", "good", nil)
+	dispatcher.Process("cmd", "bad", nil)
+
+	Eventually(handled).Should(Receive(Equal("good")))
+	Eventually(handled).Should(Receive(Equal("bad")))
+
+	Eventually(func() int {
+		n, _ := storage.Cleanup(context.Background(), "cmd", 0)
+		return n
+	}).Should(Equal(1), "the successful task should be done")
+
+	Eventually(func() int {
+		dead, _ := storage.DeadTasks(context.Background(), "cmd", 10)
+		return len(dead)
+	}).Should(Equal(1), "the failed task should be dead once its retries are exhausted")
+}
+
+func TestReceiverBoundsConcurrency(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage := memory.NewTaskStorage()
+	handled := make(chan string, 100)
+
+	var running, peak int32
+
+	dispatcher := taskworker.NewDispatcher(storage)
+	for i := 0; i < 20; i++ {
+		dispatcher.Process("cmd", fmt.Sprint(i), nil)
+	}
+
+	_, stop := startReceiver(storage, "cmd",
+		taskworker.WithConcurrency(3),
+		taskworker.WithBatchSize(7),
+		taskworker.WithRetryPolicy(taskworker.RetryPolicy{MaxAttempts: 1}),
+		taskworker.WithWorkHandler(func(task *taskworker.Task) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+
+			for {
+				max := atomic.LoadInt32(&peak)
+				if n <= max || atomic.CompareAndSwapInt32(&peak, max, n) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+			handled <- task.TaskID
+
+			if id, _ := strconv.Atoi(task.TaskID); id%2 == 1 {
+				return errors.New("boom")
+			}
+
+			return nil
+		}),
+	)
+	defer stop()
+
+	seen := map[string]int{}
+	for i := 0; i < 20; i++ {
+		var id string
+		Eventually(handled, time.Second).Should(Receive(&id))
+		seen[id]++
+	}
+	Consistently(handled, 100*time.Millisecond).ShouldNot(Receive(), "no task should be handled twice")
+	Expect(seen).To(HaveLen(20))
+
+	Expect(atomic.LoadInt32(&peak)).To(Equal(int32(3)), "the receiver should use, and never exceed, all of its slots")
+
+	Eventually(func() int {
+		dead, _ := storage.DeadTasks(context.Background(), "cmd", 100)
+		return len(dead)
+	}).Should(Equal(10), "every failed task should be buried exactly once")
+
+	n, err := storage.Cleanup(context.Background(), "cmd", 0)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(n).To(Equal(10), "every successful task should be completed exactly once")
+}
+
+func TestReceiverHandlesDelayedTasksOnTime(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage := memory.NewTaskStorage()
+	handled := make(chan time.Time, 1)
+
+	receiver := taskworker.NewReceiver(storage, "cmd",
+		taskworker.WithTick(10),
+		taskworker.WithWorkHandler(func(task *taskworker.Task) error {
+			handled <- time.Now()
+
+			return nil
+		}),
+	)
+	stopped := start(receiver)
+
+	dispatched := time.Now()
+	taskworker.NewDispatcher(storage).ProcessAfter("cmd", "1", nil, 50*time.Millisecond)
+
+	var at time.Time
+	Eventually(handled, time.Second).Should(Receive(&at), "the default receiver should not hold delayed tasks back any longer")
+	Expect(at.Sub(dispatched)).To(BeNumerically(">=", 50*time.Millisecond), "a delayed task should not run early")
+
+	Expect(receiver.Stop()).To(Succeed())
+	Eventually(stopped, time.Second*2).Should(Receive(BeNil()))
+}
+
+func TestReceiverTimesOutHungTasks(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage := memory.NewTaskStorage()
+
+	_, stop := startReceiver(storage, "cmd",
+		taskworker.WithTaskTimeout(time.Hour),
+		taskworker.WithWorkHandler(func(task *taskworker.Task) error {
+			time.Sleep(100 * time.Millisecond)
+			return nil
+		}),
+		taskworker.WithRetryPolicy(taskworker.RetryPolicy{MaxAttempts: 1}),
+	)
+	defer stop()
+
+	dispatcher := taskworker.NewDispatcher(storage)
+	dispatcher.Process("cmd", "hung", nil, taskworker.WithExecutionTimeout(50*time.Millisecond))
+
+	Eventually(func() string {
+		dead, _ := storage.DeadTasks(context.Background(), "cmd", 10)
+		if len(dead) == 0 {
+			return ""
+		}
+		return dead[0].LastError
+	}).Should(ContainSubstring("timed out after 50ms"), "the task timeout should override the receiver's")
+}
+
+func TestReceiverHoldsSlotsOfTimedOutTasks(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage := memory.NewTaskStorage()
+
+	var running, peak int32
+
+	dispatcher := taskworker.NewDispatcher(storage)
+	for i := 0; i < 6; i++ {
+		dispatcher.Process("cmd", fmt.Sprint(i), nil)
+	}
+
+	_, stop := startReceiver(storage, "cmd",
+		taskworker.WithConcurrency(2),
+		taskworker.WithTaskTimeout(10*time.Millisecond),
+		taskworker.WithRetryPolicy(taskworker.RetryPolicy{MaxAttempts: 1}),
+		// the handler ignores its context, so it keeps running well past the timeout
+		taskworker.WithWorkHandler(func(task *taskworker.Task) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+
+			for {
+				max := atomic.LoadInt32(&peak)
+				if n <= max || atomic.CompareAndSwapInt32(&peak, max, n) {
+					break
+				}
+			}
+
+			time.Sleep(50 * time.Millisecond)
+
+			return nil
+		}),
+	)
+	defer stop()
+
+	Eventually(func() []*taskworker.Task {
+		dead, _ := storage.DeadTasks(context.Background(), "cmd", 10)
+		return dead
+	}, 2*time.Second).Should(HaveLen(6))
+
+	dead, _ := storage.DeadTasks(context.Background(), "cmd", 10)
+	for _, task := range dead {
+		Expect(task.LastError).To(ContainSubstring("timed out after 10ms"))
+	}
+	Expect(atomic.LoadInt32(&peak)).To(Equal(int32(2)), "a timed out handler should hold its slot until it returns")
+}
+
+func TestReceiverRecoversFromPanics(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage := memory.NewTaskStorage()
+	handled := make(chan string, 2)
+
+	receiver, stop := startReceiver(storage, "cmd",
+		taskworker.WithWorkHandler(func(task *taskworker.Task) error {
+			if task.TaskID == "panic" {
+				panic("boom")
+			}
+
+			handled <- task.TaskID
+
+			return nil
+		}),
+		taskworker.WithRetryPolicy(taskworker.RetryPolicy{MaxAttempts: 1}),
+	)
+	defer stop()
+
+	dispatcher := taskworker.NewDispatcher(storage)
+	dispatcher.Process("cmd", "panic", nil)
+	dispatcher.Process("cmd", "good", nil)
+
+	Eventually(handled).Should(Receive(Equal("good")), "the receiver should carry on after a panic")
+
+	Eventually(func() string {
+		dead, _ := storage.DeadTasks(context.Background(), "cmd", 10)
+		if len(dead) == 0 {
+			return ""
+		}
+		return dead[0].LastError
+	}).Should(And(ContainSubstring("task handler panicked: boom"), ContainSubstring("goroutine")))
+	Expect(receiver.Panics()).To(Equal(1))
+}
+
+func TestReceiverStoresResults(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage := memory.NewTaskStorage()
+
+	_, stop := startReceiver(storage, "export",
+		taskworker.WithResultHandler(func(_ context.Context, task *taskworker.Task) (interface{}, error) {
+			return map[string]string{"url": "https://example.com/" + task.TaskID + ".csv"}, nil
+		}),
+	)
+	defer stop()
+
+	dispatcher := taskworker.NewDispatcher(storage)
+
+	found, err := dispatcher.Result("export", "1", &struct{}{})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(found).To(BeFalse(), "a task which is not completed has no result")
+
+	dispatcher.Process("export", "1", nil)
+
+	result := struct {
+		URL string `json:"url"`
+	}{}
+	Eventually(func() bool {
+		found, _ := dispatcher.Result("export", "1", &result)
+		return found
+	}).Should(BeTrue())
+	Expect(result.URL).To(Equal("https://example.com/1.csv"))
+
+	found, err = dispatcher.Result("import", "1", &result)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(found).To(BeFalse(), "results should be looked up by action as well as by ID")
+
+	storage.Cleanup(context.Background(), "export", 0)
+	found, err = dispatcher.Result("export", "1", &result)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(found).To(BeFalse(), "results should be removed with their task")
+}
+
+func TestReceiverRestartsAfterDrainTimeout(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage := memory.NewTaskStorage()
+	handled := make(chan string, 1)
+
+	receiver, stop := startReceiver(storage, "cmd",
+		taskworker.WithDrainTimeout(10*time.Millisecond),
+		taskworker.WithContextHandler(func(ctx context.Context, task *taskworker.Task) error {
+			if task.TaskID == "hung" {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+
+			handled <- task.TaskID
+
+			return nil
+		}),
+	)
+
+	dispatcher := taskworker.NewDispatcher(storage)
+	dispatcher.Process("cmd", "hung", nil)
+	Eventually(receiver.InFlight).Should(Equal(1))
+
+	stop()
+	Expect(receiver.InFlight()).To(Equal(0), "the hung task should be cancelled once the drain timeout is exceeded")
+
+	stopped := start(receiver)
+
+	dispatcher.Process("cmd", "next", nil)
+	Eventually(handled).Should(Receive(Equal("next")), "a restarted receiver should handle tasks again")
+
+	Expect(receiver.Stop()).To(Succeed())
+	Eventually(stopped, time.Second*2).Should(Receive(BeNil()))
+}
+
+func TestReceiverStartsNoTaskAfterStop(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage := memory.NewTaskStorage()
+	started := make(chan string, 10)
+	limiter := &gate{open: make(chan bool)}
+
+	dispatcher := taskworker.NewDispatcher(storage)
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		dispatcher.Process("cmd", id, nil)
+	}
+
+	receiver := taskworker.NewReceiver(storage, "cmd",
+		taskworker.WithTick(10),
+		taskworker.WithTaskAge(0),
+		taskworker.WithRateLimiter(limiter),
+		taskworker.WithWorkHandler(func(task *taskworker.Task) error {
+			started <- task.TaskID
+
+			return nil
+		}),
+	)
+	stopped := start(receiver)
+
+	Eventually(started).Should(Receive(Equal("1")))
+	Eventually(receiver.InFlight).Should(Equal(0))
+
+	// the next task gets its token once the receiver is stopping and a slot is free
+	Expect(receiver.Stop()).To(Succeed())
+	close(limiter.open)
+	Eventually(stopped, time.Second*2).Should(Receive(BeNil()))
+
+	Expect(started).ToNot(Receive(), "no task should start once the receiver is stopping")
+
+	released, err := storage.GetBatch(context.Background(), []string{"cmd"}, 0, time.Minute, 10)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(released).To(HaveLen(4), "the tasks which did not start should be released")
+}
+
+func TestReceiverKillWhileDraining(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage := memory.NewTaskStorage()
+	cancelled := make(chan bool, 1)
+
+	receiver := taskworker.NewReceiver(storage, "cmd",
+		taskworker.WithNotifier(storage),
+		taskworker.WithTick(10),
+		taskworker.WithTaskAge(0),
+		taskworker.WithDrainTimeout(time.Hour),
+		taskworker.WithContextHandler(func(ctx context.Context, task *taskworker.Task) error {
+			<-ctx.Done()
+			cancelled <- true
+
+			return ctx.Err()
+		}),
+	)
+	stopped := start(receiver)
+
+	taskworker.NewDispatcher(storage).Process("cmd", "1", nil)
+	Eventually(receiver.InFlight).Should(Equal(1))
+
+	Expect(receiver.Stop()).To(Succeed())
+	Expect(receiver.Kill()).To(Succeed(), "a draining receiver should be killable")
+
+	Eventually(cancelled).Should(Receive(), "killing should cancel the handlers' context")
+	Eventually(stopped, time.Second*2).Should(Receive(BeNil()))
+}
+
+func TestReceiverKeepsWholeBatchLeased(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage := memory.NewTaskStorage()
+	handled := make(chan string, 20)
+
+	dispatcher := taskworker.NewDispatcher(storage)
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		dispatcher.Process("cmd", id, nil)
+	}
+
+	opts := []taskworker.ReceiverOption{
+		taskworker.WithLease(60 * time.Millisecond),
+		taskworker.WithReclaimInterval(10 * time.Millisecond),
+		taskworker.WithWorkHandler(func(task *taskworker.Task) error {
+			time.Sleep(40 * time.Millisecond)
+			handled <- task.TaskID
+
+			return nil
+		}),
+	}
+
+	// the first receiver claims the whole batch, the second one would pick up any task whose lease expired
+	receiver, stop := startReceiver(storage, "cmd", opts...)
+	defer stop()
+	Eventually(receiver.InFlight).Should(Equal(1))
+	_, stopOther := startReceiver(storage, "cmd", opts...)
+	defer stopOther()
+
+	seen := map[string]int{}
+	for i := 0; i < 5; i++ {
+		var id string
+		Eventually(handled, time.Second).Should(Receive(&id))
+		seen[id]++
+	}
+	Consistently(handled, 200*time.Millisecond).ShouldNot(Receive(), "no task should be handled twice")
+	Expect(seen).To(HaveLen(5))
+}
+
+func TestReceiverKeepsThrottledTasksLeased(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage := memory.NewTaskStorage()
+	handled := make(chan string, 20)
+
+	dispatcher := taskworker.NewDispatcher(storage)
+	for _, id := range []string{"1", "2", "3", "4"} {
+		dispatcher.Process("cmd", id, nil)
+	}
+
+	opts := []taskworker.ReceiverOption{
+		taskworker.WithLease(60 * time.Millisecond),
+		taskworker.WithReclaimInterval(10 * time.Millisecond),
+		taskworker.WithRateLimit(10, 1),
+		taskworker.WithWorkHandler(func(task *taskworker.Task) error {
+			handled <- task.TaskID
+
+			return nil
+		}),
+	}
+
+	// the batch is throttled for longer than the lease while a second receiver waits to pick up expired tasks
+	_, stop := startReceiver(storage, "cmd", opts...)
+	defer stop()
+	Eventually(handled).Should(Receive(Equal("1")))
+	_, stopOther := startReceiver(storage, "cmd", opts...)
+	defer stopOther()
+
+	seen := map[string]int{"1": 1}
+	for i := 0; i < 3; i++ {
+		var id string
+		Eventually(handled, time.Second).Should(Receive(&id))
+		seen[id]++
+	}
+	Consistently(handled, 200*time.Millisecond).ShouldNot(Receive(), "no task should be handled twice")
+	Expect(seen).To(HaveLen(4))
+}
+
+// gate is a RateLimiter which lets the first task through and holds the others, whatever their context, until open
+// is closed.
+type gate struct {
+	calls int32
+	open  chan bool
+}
+
+func (g *gate) Wait(ctx context.Context) error {
+	if atomic.AddInt32(&g.calls, 1) > 1 {
+		<-g.open
+	}
+
+	return nil
+}
+
+// startReceiver starts a receiver for command which polls storage every 10ms and claims tasks of any age. The returned
+// function stops the receiver and waits until it is stopped.
+func TestReceiverBuriesTasksWhoseLeaseKeepsExpiring(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	storage := memory.NewTaskStorage()
+	storage.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd"})
+
+	// each claim stands for a receiver which crashed while handling the task
+	for i := 0; i < 3; i++ {
+		task, _ := storage.Get(ctx, "cmd", 0, time.Millisecond)
+		Expect(task).ToNot(BeNil())
+
+		time.Sleep(5 * time.Millisecond)
+		Expect(storage.Reclaim(ctx, []string{"cmd"})).To(Equal(1))
+	}
+
+	var handled int64
+
+	_, stop := startReceiver(storage, "cmd",
+		taskworker.WithRetryPolicy(taskworker.RetryPolicy{MaxAttempts: 3}),
+		taskworker.WithWorkHandler(func(*taskworker.Task) error {
+			atomic.AddInt64(&handled, 1)
+			return nil
+		}),
+	)
+	defer stop()
+
+	Eventually(func() []*taskworker.Task {
+		dead, _ := storage.DeadTasks(ctx, "cmd", 10)
+		return dead
+	}).Should(ConsistOf(WithTransform(func(task *taskworker.Task) string {
+		return task.LastError
+	}, Equal(taskworker.LeaseExpired))))
+	Expect(atomic.LoadInt64(&handled)).To(BeZero(), "a task whose retries are exhausted should not be handled again")
+}
+
+func startReceiver(storage *memory.TaskStorage, command string, opts ...taskworker.ReceiverOption) (*taskworker.Receiver, func()) {
+	opts = append([]taskworker.ReceiverOption{
+		taskworker.WithNotifier(storage),
+		taskworker.WithTick(10),
+		taskworker.WithTaskAge(0),
+	}, opts...)
+
+	receiver := taskworker.NewReceiver(storage, command, opts...)
+	stopped := start(receiver)
+
+	return receiver, func() {
+		Expect(receiver.Stop()).To(Succeed())
+		Eventually(stopped, time.Second*2).Should(Receive(BeNil()))
+	}
+}
+
+// start runs the receiver in the background and returns a channel which receives the result of Start.
+func start(receiver *taskworker.Receiver) <-chan error {
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- receiver.Start()
+	}()
+
+	return stopped
+}
@@ -0,0 +1,933 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+// record is a stored task along with the number of its parents which are not done yet.
+type record struct {
+	task    taskworker.Task
+	pending int
+}
+
+// group tracks the members of a task group.
+type group struct {
+	total     int
+	completed int
+	dead      int
+	onDead    bool
+	fired     bool
+	callback  taskworker.Task
+	data      []byte
+}
+
+// key identifies a task for deduplication.
+type key struct {
+	action string
+	taskID string
+}
+
+const (
+	stepWaiting = iota
+	stepDone
+	stepDead
+)
+
+// step is the state of a task within its workflow. It outlives the task, so its children can tell whether it was done.
+type step struct {
+	action     string
+	state      int
+	finishedAt time.Time
+}
+
+// stepKey identifies a task within its workflow.
+type stepKey struct {
+	workflowID string
+	taskID     string
+}
+
+// StorageOption is the abstract functional-parameter type used for storage configuration.
+type StorageOption func(*TaskStorage)
+
+// WithDedupWindow allows you to keep deduplicating a task for the given duration after it is completed. By default a
+// task is only deduplicated while it is in the 'todo' or 'doing' state.
+func WithDedupWindow(window time.Duration) StorageOption {
+	return func(s *TaskStorage) {
+		if window >= 0 {
+			s.window = window
+		}
+	}
+}
+
+// TaskStorage keeps tasks in memory, following the same lifecycle as the postgres storage. It is safe for concurrent
+// receivers within a process, but tasks are lost once the process exits. It also wakes up subscribed receivers as a
+// taskworker.TaskNotifier.
+type TaskStorage struct {
+	mux         *sync.Mutex
+	seq         int
+	claims      int
+	window      time.Duration
+	todo        map[int]*record
+	doing       map[int]*record
+	done        map[int]*record
+	dead        map[int]*record
+	groups      map[string]*group
+	keys        map[key]time.Time
+	steps       map[stepKey]*step
+	subscribers map[chan bool][]string
+	now         func() time.Time
+}
+
+// NewTaskStorage creates an empty task storage.
+func NewTaskStorage(opts ...StorageOption) *TaskStorage {
+	s := &TaskStorage{
+		mux:         &sync.Mutex{},
+		todo:        make(map[int]*record),
+		doing:       make(map[int]*record),
+		done:        make(map[int]*record),
+		dead:        make(map[int]*record),
+		groups:      make(map[string]*group),
+		keys:        make(map[key]time.Time),
+		steps:       make(map[stepKey]*step),
+		subscribers: make(map[chan bool][]string),
+		now:         time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Create stores a task for processing. It returns false if the task was deduplicated.
+func (s *TaskStorage) Create(ctx context.Context, task *taskworker.Task) (bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	data, err := json.Marshal(task.Data)
+	if err != nil {
+		return false, errors.Wrap(err, "error occurred creating the task")
+	}
+
+	if err := s.admit([]*taskworker.Task{task}); err != nil {
+		return false, err
+	}
+
+	return s.insert(task, data), nil
+}
+
+// CreateBatch stores several tasks for processing, either all of them or none, and returns how many were stored. Tasks
+// which are deduplicated are skipped rather than failing the batch.
+func (s *TaskStorage) CreateBatch(ctx context.Context, tasks []*taskworker.Task) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	data, err := encode(tasks)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.admit(tasks); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i, task := range tasks {
+		if s.insert(task, data[i]) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// CreateGroup stores the tasks of a group for processing, either all of them or none. Completing or burying the last
+// member adds the group callback.
+func (s *TaskStorage) CreateGroup(ctx context.Context, g *taskworker.Group, tasks []*taskworker.Task) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.groups[g.ID]; ok {
+		return errors.New("group already exists")
+	}
+
+	callback, err := json.Marshal(g.Callback.Data)
+	if err != nil {
+		return errors.Wrap(err, "error occurred creating the group")
+	}
+
+	data, err := encode(tasks)
+	if err != nil {
+		return err
+	}
+
+	if err := s.admit(tasks); err != nil {
+		return err
+	}
+
+	total := 0
+	for i, task := range tasks {
+		task.GroupID = g.ID
+		if s.insert(task, data[i]) {
+			total++
+		}
+	}
+
+	s.groups[g.ID] = &group{
+		total:    total,
+		onDead:   g.CallbackOnDead,
+		callback: *g.Callback,
+		data:     callback,
+	}
+
+	// a group whose members were all deduplicated is finished already
+	return s.settle(g.ID, 0, 0)
+}
+
+// Get returns the next Task for command which is in the 'todo' state, leasing it for the given duration.
+func (s *TaskStorage) Get(ctx context.Context, command string, age time.Duration, lease time.Duration) (*taskworker.Task, error) {
+	tasks, err := s.GetBatch(ctx, []string{command}, age, lease, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tasks) == 0 {
+		return nil, nil
+	}
+
+	return tasks[0], nil
+}
+
+// GetBatch returns the next N Tasks matching any of the action patterns which are in the 'todo' state, leasing them
+// for the given duration.
+func (s *TaskStorage) GetBatch(ctx context.Context, actions []string, age time.Duration, lease time.Duration, n int) ([]*taskworker.Task, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := s.now()
+
+	claimable := make([]*record, 0)
+	for _, rec := range s.todo {
+		if !matches(actions, rec.task.Action) ||
+			rec.pending > 0 ||
+			rec.task.CreatedAt.After(now.Add(-age)) ||
+			rec.task.RunAt.After(now) ||
+			rec.task.NextRunAt.After(now) {
+			continue
+		}
+
+		claimable = append(claimable, rec)
+	}
+
+	sort.Slice(claimable, func(i, j int) bool {
+		a, b := claimable[i].task, claimable[j].task
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+
+		return a.ID < b.ID
+	})
+
+	if len(claimable) > n {
+		claimable = claimable[:n]
+	}
+
+	s.claims++
+	token := fmt.Sprint(s.claims)
+
+	tasks := make([]*taskworker.Task, 0, len(claimable))
+	for _, rec := range claimable {
+		delete(s.todo, rec.task.ID)
+
+		rec.task.StartedAt = now
+		rec.task.LeaseExpiresAt = now.Add(lease)
+		rec.task.LeaseToken = token
+		s.doing[rec.task.ID] = rec
+
+		tasks = append(tasks, clone(rec.task))
+	}
+
+	return tasks, nil
+}
+
+// Extend pushes the lease of a task in the 'doing' state to the given duration from now.
+func (s *TaskStorage) Extend(ctx context.Context, task *taskworker.Task, lease time.Duration) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	rec, ok := s.held(task)
+	if !ok {
+		return errors.New("task is no longer leased")
+	}
+
+	rec.task.LeaseExpiresAt = s.now().Add(lease)
+	task.LeaseExpiresAt = rec.task.LeaseExpiresAt
+
+	return nil
+}
+
+// Reclaim moves all tasks matching any of the action patterns in the 'doing' state whose lease has expired back to the
+// 'todo' state, counting a failed attempt with LeaseExpired as its error.
+func (s *TaskStorage) Reclaim(ctx context.Context, actions []string) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := s.now()
+
+	count := 0
+	for id, rec := range s.doing {
+		if matches(actions, rec.task.Action) && rec.task.LeaseExpiresAt.Before(now) {
+			rec.task.Attempts++
+			rec.task.LastError = taskworker.LeaseExpired
+			s.reopen(id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Release moves tasks which were claimed but never handled back to the 'todo' state.
+func (s *TaskStorage) Release(ctx context.Context, tasks []*taskworker.Task) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, task := range tasks {
+		if _, ok := s.held(task); ok {
+			s.reopen(task.ID)
+		}
+	}
+
+	return nil
+}
+
+// Retry moves all tasks for command in the 'doing' state started more than 'age' ago whose lease has expired back to
+// the 'todo' state, counting a failed attempt with LeaseExpired as its error. Tasks whose lease is still being extended
+// are left to their handler.
+func (s *TaskStorage) Retry(ctx context.Context, command string, age time.Duration) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := s.now()
+
+	count := 0
+	for id, rec := range s.doing {
+		if rec.task.Action != command || rec.task.StartedAt.After(now.Add(-age)) || !rec.task.LeaseExpiresAt.Before(now) {
+			continue
+		}
+
+		rec.task.Attempts++
+		rec.task.LastError = taskworker.LeaseExpired
+		s.reopen(id)
+		count++
+	}
+
+	return count, nil
+}
+
+// Cleanup removes all tasks for command in the 'done' state finished more than 'age' ago, along with the workflows
+// involving command whose tasks were all done by then.
+func (s *TaskStorage) Cleanup(ctx context.Context, command string, age time.Duration) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := s.now()
+
+	count := 0
+	for id, rec := range s.done {
+		if rec.task.Action == command && !rec.task.FinishedAt.After(now.Add(-age)) {
+			delete(s.done, id)
+			count++
+		}
+	}
+
+	// a workflow is kept while any of its tasks may still wait for the others
+	finished := make(map[string]bool)
+	for k, st := range s.steps {
+		if st.action == command {
+			finished[k.workflowID] = true
+		}
+	}
+
+	for k, st := range s.steps {
+		if st.state != stepDone || st.finishedAt.After(now.Add(-age)) {
+			delete(finished, k.workflowID)
+		}
+	}
+
+	for k := range s.steps {
+		if finished[k.workflowID] {
+			delete(s.steps, k)
+		}
+	}
+
+	for k, expiresAt := range s.keys {
+		if k.action == command && !expiresAt.IsZero() && !expiresAt.After(now) {
+			delete(s.keys, k)
+		}
+	}
+
+	return count, nil
+}
+
+// Result returns the last task for action with the given task ID in the 'done' state, with its result, or nil if there
+// is none.
+func (s *TaskStorage) Result(ctx context.Context, action string, taskID string) (*taskworker.Task, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var last *record
+	for _, rec := range s.done {
+		if rec.task.Action == action && rec.task.TaskID == taskID && (last == nil || !rec.task.FinishedAt.Before(last.task.FinishedAt)) {
+			last = rec
+		}
+	}
+
+	if last == nil {
+		return nil, nil
+	}
+
+	return clone(last.task), nil
+}
+
+// Complete moves a task to the 'done' state, storing its result.
+func (s *TaskStorage) Complete(ctx context.Context, task *taskworker.Task) error {
+	var result json.RawMessage
+	if task.Result != nil {
+		var err error
+		if result, err = json.Marshal(task.Result); err != nil {
+			return errors.Wrap(err, "error occurred encoding the task result")
+		}
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	rec, ok := s.held(task)
+	if !ok {
+		return errors.New("task is no longer in the doing state")
+	}
+
+	delete(s.doing, task.ID)
+
+	rec.task.LeaseExpiresAt = time.Time{}
+	rec.task.LeaseToken = ""
+	rec.task.FinishedAt = s.now()
+	if result != nil {
+		rec.task.Result = result
+	}
+	s.done[task.ID] = rec
+
+	task.FinishedAt = rec.task.FinishedAt
+	task.GroupID = rec.task.GroupID
+
+	s.keys[key{rec.task.Action, rec.task.TaskID}] = rec.task.FinishedAt.Add(s.window)
+	s.unblock(rec.task.WorkflowID, rec.task.TaskID)
+
+	return s.settle(rec.task.GroupID, 1, 0)
+}
+
+// Fail moves the task back to the 'todo' state, increasing its attempts count, so it is claimable again after the delay.
+func (s *TaskStorage) Fail(ctx context.Context, task *taskworker.Task, reason string, delay time.Duration) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	rec, ok := s.held(task)
+	if !ok {
+		return errors.New("task is no longer in the doing state")
+	}
+
+	s.reopen(task.ID)
+
+	rec.task.Attempts++
+	rec.task.LastError = reason
+	rec.task.NextRunAt = s.now().Add(delay)
+
+	task.Attempts = rec.task.Attempts
+	task.LastError = reason
+	task.NextRunAt = rec.task.NextRunAt
+
+	return nil
+}
+
+// Bury moves the task to the 'dead' state, where it stays until it is requeued or purged.
+func (s *TaskStorage) Bury(ctx context.Context, task *taskworker.Task, reason string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	rec, ok := s.held(task)
+	if !ok {
+		return errors.New("task is no longer in the doing state")
+	}
+
+	delete(s.doing, task.ID)
+
+	rec.task.Attempts++
+	if err := s.bury(rec, reason); err != nil {
+		return err
+	}
+
+	task.Attempts = rec.task.Attempts
+	task.LastError = reason
+	task.DeadAt = rec.task.DeadAt
+	task.GroupID = rec.task.GroupID
+
+	return s.cascade(rec.task.WorkflowID, rec.task.TaskID)
+}
+
+// Snooze moves the task back to the 'todo' state without counting an attempt, so it is claimable again once until passes.
+func (s *TaskStorage) Snooze(ctx context.Context, task *taskworker.Task, until time.Time) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	rec, ok := s.held(task)
+	if !ok {
+		return errors.New("task is no longer in the doing state")
+	}
+
+	s.reopen(task.ID)
+
+	rec.task.NextRunAt = until
+	task.NextRunAt = until
+
+	return nil
+}
+
+// Discard removes the task without moving it to the 'done' nor the 'dead' state. It counts as completed both for its
+// group and for the tasks waiting for it, since the work it stood for is no longer needed.
+func (s *TaskStorage) Discard(ctx context.Context, task *taskworker.Task) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	rec, ok := s.held(task)
+	if !ok {
+		return errors.New("task is no longer in the doing state")
+	}
+
+	delete(s.doing, task.ID)
+	delete(s.keys, key{rec.task.Action, rec.task.TaskID})
+
+	task.GroupID = rec.task.GroupID
+
+	if err := s.settle(rec.task.GroupID, 1, 0); err != nil {
+		return err
+	}
+
+	s.unblock(rec.task.WorkflowID, rec.task.TaskID)
+
+	return nil
+}
+
+// DeadTasks returns up to N tasks in the 'dead' state for action, most recent first. An empty action matches all actions.
+func (s *TaskStorage) DeadTasks(ctx context.Context, action string, n int) ([]*taskworker.Task, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	dead := make([]*record, 0)
+	for _, rec := range s.dead {
+		if action == "" || rec.task.Action == action {
+			dead = append(dead, rec)
+		}
+	}
+
+	sort.Slice(dead, func(i, j int) bool {
+		return dead[i].task.DeadAt.After(dead[j].task.DeadAt)
+	})
+
+	if len(dead) > n {
+		dead = dead[:n]
+	}
+
+	tasks := make([]*taskworker.Task, 0, len(dead))
+	for _, rec := range dead {
+		tasks = append(tasks, clone(rec.task))
+	}
+
+	return tasks, nil
+}
+
+// Requeue moves a task in the 'dead' state back to the 'todo' state with its attempts reset.
+func (s *TaskStorage) Requeue(ctx context.Context, id int) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.dead[id]; !ok {
+		return errors.New("task is not in the dead state")
+	}
+
+	s.requeue(id)
+
+	return nil
+}
+
+// RequeueAll moves all tasks for action in the 'dead' state back to the 'todo' state. An empty action matches all actions.
+func (s *TaskStorage) RequeueAll(ctx context.Context, action string) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	count := 0
+	for id, rec := range s.dead {
+		if action == "" || rec.task.Action == action {
+			s.requeue(id)
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Purge removes all tasks for action in the 'dead' state. An empty action matches all actions.
+func (s *TaskStorage) Purge(ctx context.Context, action string) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	count := 0
+	for id, rec := range s.dead {
+		if action == "" || rec.task.Action == action {
+			delete(s.dead, id)
+			delete(s.steps, stepKey{rec.task.WorkflowID, rec.task.TaskID})
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Subscribe returns a channel which receives a signal whenever a task matching any of the action patterns is created.
+// Signals are coalesced while nobody reads them. Call the returned function to unsubscribe.
+func (s *TaskStorage) Subscribe(actions ...string) (<-chan bool, func()) {
+	wake := make(chan bool, 1)
+
+	s.mux.Lock()
+	s.subscribers[wake] = actions
+	s.mux.Unlock()
+
+	return wake, func() {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+
+		delete(s.subscribers, wake)
+	}
+}
+
+// admit checks that the tasks can be stored together, with the workflow tasks among them neither deduplicated nor
+// waiting for tasks which will never be done.
+func (s *TaskStorage) admit(tasks []*taskworker.Task) error {
+	keys := make(map[key]bool)
+	steps := make(map[stepKey]bool)
+
+	for _, task := range tasks {
+		if task.WorkflowID == "" {
+			if len(task.Parents) > 0 {
+				return errors.Errorf("task %s has parents but is not part of a workflow", task.TaskID)
+			}
+
+			continue
+		}
+
+		k := key{task.Action, task.TaskID}
+		if s.taken(k) || keys[k] {
+			return errors.Errorf("task %s of workflow %s is already waiting or running", task.TaskID, task.WorkflowID)
+		}
+
+		sk := stepKey{task.WorkflowID, task.TaskID}
+		if _, ok := s.steps[sk]; ok || steps[sk] {
+			return errors.Errorf("task %s is already part of workflow %s", task.TaskID, task.WorkflowID)
+		}
+
+		for _, parent := range task.Parents {
+			if steps[stepKey{task.WorkflowID, parent}] {
+				continue
+			}
+
+			st, ok := s.steps[stepKey{task.WorkflowID, parent}]
+			if !ok {
+				return errors.Errorf("parent %s of task %s is not part of workflow %s", parent, task.TaskID, task.WorkflowID)
+			}
+
+			if st.state == stepDead {
+				return errors.Errorf("parent %s of task %s is dead", parent, task.TaskID)
+			}
+		}
+
+		keys[k] = true
+		steps[sk] = true
+	}
+
+	return nil
+}
+
+// taken tells if a task holds the dedup key.
+func (s *TaskStorage) taken(k key) bool {
+	expiresAt, ok := s.keys[k]
+
+	return ok && (expiresAt.IsZero() || expiresAt.After(s.now()))
+}
+
+// insert stores a task in the 'todo' state and notifies its subscribers, unless a task with the same action and ID
+// holds its dedup key. It returns whether the task was stored.
+func (s *TaskStorage) insert(task *taskworker.Task, data []byte) bool {
+	now := s.now()
+
+	k := key{task.Action, task.TaskID}
+	if s.taken(k) {
+		return false
+	}
+
+	s.seq++
+	s.keys[k] = time.Time{}
+
+	runAt := task.RunAt
+	if runAt.IsZero() {
+		runAt = now
+	}
+
+	s.todo[s.seq] = &record{
+		task: taskworker.Task{
+			ID:         s.seq,
+			TaskID:     task.TaskID,
+			Action:     task.Action,
+			Data:       data,
+			CreatedAt:  now,
+			GroupID:    task.GroupID,
+			WorkflowID: task.WorkflowID,
+			Parents:    append([]string(nil), task.Parents...),
+			Priority:   task.Priority,
+			RunAt:      runAt,
+			Timeout:    task.Timeout,
+		},
+		pending: s.pending(task.WorkflowID, task.Parents),
+	}
+
+	if task.WorkflowID != "" {
+		s.steps[stepKey{task.WorkflowID, task.TaskID}] = &step{action: task.Action}
+	}
+
+	s.notify(task.Action)
+
+	return true
+}
+
+// reopen moves a task in the 'doing' state back to the 'todo' state.
+func (s *TaskStorage) reopen(id int) {
+	rec := s.doing[id]
+	delete(s.doing, id)
+
+	rec.task.StartedAt = time.Time{}
+	rec.task.LeaseExpiresAt = time.Time{}
+	rec.task.LeaseToken = ""
+	s.todo[id] = rec
+
+	s.notify(rec.task.Action)
+}
+
+// held returns the record of a task in the 'doing' state, unless it was claimed again since task was claimed.
+func (s *TaskStorage) held(task *taskworker.Task) (*record, bool) {
+	rec, ok := s.doing[task.ID]
+	if !ok || rec.task.LeaseToken != task.LeaseToken {
+		return nil, false
+	}
+
+	return rec, true
+}
+
+// bury moves a task to the 'dead' state and lets its dedup key go.
+func (s *TaskStorage) bury(rec *record, reason string) error {
+	rec.task.LastError = reason
+	rec.task.LeaseExpiresAt = time.Time{}
+	rec.task.LeaseToken = ""
+	rec.task.DeadAt = s.now()
+	s.dead[rec.task.ID] = rec
+
+	delete(s.keys, key{rec.task.Action, rec.task.TaskID})
+
+	return s.settle(rec.task.GroupID, 0, 1)
+}
+
+// requeue moves a task in the 'dead' state back to the 'todo' state, waiting again for parents which are not done.
+func (s *TaskStorage) requeue(id int) {
+	rec := s.dead[id]
+	delete(s.dead, id)
+
+	rec.task.Attempts = 0
+	rec.task.NextRunAt = time.Time{}
+	rec.task.DeadAt = time.Time{}
+	rec.pending = s.pending(rec.task.WorkflowID, rec.task.Parents)
+	s.todo[id] = rec
+
+	if st, ok := s.steps[stepKey{rec.task.WorkflowID, rec.task.TaskID}]; ok {
+		st.state = stepWaiting
+	}
+
+	s.keys[key{rec.task.Action, rec.task.TaskID}] = time.Time{}
+
+	if g, ok := s.groups[rec.task.GroupID]; ok && !g.fired {
+		g.dead--
+	}
+
+	s.notify(rec.task.Action)
+}
+
+// settle counts a finished member of a group and adds the group callback once every member is finished.
+func (s *TaskStorage) settle(groupID string, completed int, dead int) error {
+	g, ok := s.groups[groupID]
+	if !ok {
+		return nil
+	}
+
+	g.completed += completed
+	g.dead += dead
+
+	if g.fired || g.completed+g.dead < g.total || (g.dead > 0 && !g.onDead) {
+		return nil
+	}
+
+	summary := taskworker.GroupSummary{
+		GroupID:   groupID,
+		Total:     g.total,
+		Completed: g.completed,
+		Dead:      g.dead,
+		Data:      g.data,
+	}
+
+	for _, rec := range s.dead {
+		if rec.task.GroupID == groupID {
+			summary.DeadTaskIDs = append(summary.DeadTaskIDs, rec.task.TaskID)
+		}
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return errors.Wrap(err, "error occurred adding the group callback")
+	}
+
+	g.fired = true
+	s.insert(&g.callback, data)
+
+	return nil
+}
+
+// pending counts the parents, within the workflow, which are not done yet.
+func (s *TaskStorage) pending(workflowID string, parents []string) int {
+	pending := 0
+	for _, parent := range parents {
+		if st, ok := s.steps[stepKey{workflowID, parent}]; !ok || st.state != stepDone {
+			pending++
+		}
+	}
+
+	return pending
+}
+
+// unblock marks a completed task as done within its workflow and counts it on the tasks waiting for it.
+func (s *TaskStorage) unblock(workflowID string, taskID string) {
+	st, ok := s.steps[stepKey{workflowID, taskID}]
+	if !ok || st.state == stepDone {
+		return
+	}
+
+	st.state = stepDone
+	st.finishedAt = s.now()
+
+	for _, rec := range s.todo {
+		if rec.pending > 0 && rec.task.WorkflowID == workflowID && contains(rec.task.Parents, taskID) {
+			rec.pending--
+
+			if rec.pending == 0 {
+				s.notify(rec.task.Action)
+			}
+		}
+	}
+}
+
+// cascade marks a dead task as dead within its workflow and moves every task waiting, directly or not, for it to the
+// 'dead' state.
+func (s *TaskStorage) cascade(workflowID string, taskID string) error {
+	st, ok := s.steps[stepKey{workflowID, taskID}]
+	if !ok {
+		return nil
+	}
+
+	st.state = stepDead
+	reason := fmt.Sprintf("parent task %s is dead", taskID)
+
+	parents := []string{taskID}
+	for len(parents) > 0 {
+		parent := parents[0]
+		parents = parents[1:]
+
+		for id, rec := range s.todo {
+			if rec.task.WorkflowID == workflowID && contains(rec.task.Parents, parent) {
+				delete(s.todo, id)
+				if err := s.bury(rec, reason); err != nil {
+					return err
+				}
+
+				s.steps[stepKey{workflowID, rec.task.TaskID}].state = stepDead
+				parents = append(parents, rec.task.TaskID)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *TaskStorage) notify(action string) {
+	for subscriber, patterns := range s.subscribers {
+		if matches(patterns, action) {
+			select {
+			case subscriber <- true:
+			default:
+			}
+		}
+	}
+}
+
+func encode(tasks []*taskworker.Task) ([][]byte, error) {
+	data := make([][]byte, 0, len(tasks))
+	for _, task := range tasks {
+		d, err := json.Marshal(task.Data)
+		if err != nil {
+			return nil, errors.Wrap(err, "error occurred creating the task")
+		}
+
+		data = append(data, d)
+	}
+
+	return data, nil
+}
+
+func matches(patterns []string, action string) bool {
+	for _, pattern := range patterns {
+		if taskworker.MatchAction(pattern, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func clone(task taskworker.Task) *taskworker.Task {
+	task.Parents = append([]string(nil), task.Parents...)
+
+	return &task
+}
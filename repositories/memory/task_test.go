@@ -0,0 +1,404 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+func TestTaskLifecycle(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewTaskStorage()
+
+	created, err := s.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd", Data: map[string]string{"a": "b"}})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(created).To(BeTrue())
+
+	task, err := s.Get(ctx, "cmd", 0, time.Minute)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(task.TaskID).To(Equal("1"))
+	Expect(task.Data).To(MatchJSON(`{"a": "b"}`))
+
+	again, err := s.Get(ctx, "cmd", 0, time.Minute)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(again).To(BeNil(), "a claimed task should not be claimed twice")
+
+	Expect(s.Complete(ctx, task)).To(Succeed())
+	Expect(s.Complete(ctx, task)).ToNot(Succeed(), "a completed task is no longer in the doing state")
+
+	n, err := s.Cleanup(ctx, "cmd", 0)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(n).To(Equal(1))
+}
+
+func TestGetBatchOrderAndFilters(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewTaskStorage()
+
+	Expect(s.CreateBatch(ctx, []*taskworker.Task{
+		{TaskID: "low", Action: "cmd"},
+		{TaskID: "high", Action: "cmd", Priority: 10},
+		{TaskID: "later", Action: "cmd", RunAt: time.Now().Add(time.Hour)},
+		{TaskID: "other", Action: "other"},
+	})).To(Equal(4))
+
+	tasks, err := s.GetBatch(ctx, []string{"cmd"}, time.Minute, time.Minute, 10)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(tasks).To(BeEmpty(), "tasks younger than the age should not be claimed")
+
+	s.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+
+	tasks, err = s.GetBatch(ctx, []string{"cmd"}, time.Minute, time.Minute, 10)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(tasks).To(HaveLen(2))
+	Expect(tasks[0].TaskID).To(Equal("high"), "higher priorities should be claimed first")
+	Expect(tasks[1].TaskID).To(Equal("low"))
+}
+
+func TestCreateDeduplicates(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewTaskStorage(WithDedupWindow(time.Hour))
+
+	created, _ := s.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd"})
+	Expect(created).To(BeTrue())
+
+	created, _ = s.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd"})
+	Expect(created).To(BeFalse(), "a waiting task should be deduplicated")
+
+	task, _ := s.Get(ctx, "cmd", 0, time.Minute)
+	created, _ = s.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd"})
+	Expect(created).To(BeFalse(), "a running task should be deduplicated")
+
+	Expect(s.Complete(ctx, task)).To(Succeed())
+	created, _ = s.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd"})
+	Expect(created).To(BeFalse(), "a task completed within the window should be deduplicated")
+
+	s.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	created, _ = s.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd"})
+	Expect(created).To(BeTrue())
+}
+
+func TestFailAndBury(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewTaskStorage()
+
+	s.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd"})
+
+	task, _ := s.Get(ctx, "cmd", 0, time.Minute)
+	Expect(s.Fail(ctx, task, "boom", time.Minute)).To(Succeed())
+	Expect(task.Attempts).To(Equal(1))
+
+	again, _ := s.Get(ctx, "cmd", 0, time.Minute)
+	Expect(again).To(BeNil(), "a failed task should wait for its backoff")
+
+	s.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	task, _ = s.Get(ctx, "cmd", 0, time.Minute)
+	Expect(task).ToNot(BeNil())
+	Expect(task.LastError).To(Equal("boom"))
+
+	Expect(s.Bury(ctx, task, "boom again")).To(Succeed())
+
+	dead, err := s.DeadTasks(ctx, "", 10)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(dead).To(HaveLen(1))
+	Expect(dead[0].Attempts).To(Equal(2))
+
+	Expect(s.Requeue(ctx, dead[0].ID)).To(Succeed())
+	task, _ = s.Get(ctx, "cmd", 0, time.Minute)
+	Expect(task).ToNot(BeNil(), "a requeued task should be claimable")
+	Expect(task.Attempts).To(Equal(0))
+}
+
+func TestSnoozeAndDiscard(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewTaskStorage()
+
+	s.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd", WorkflowID: "w"})
+	s.Create(ctx, &taskworker.Task{TaskID: "2", Action: "cmd", WorkflowID: "w", Parents: []string{"1"}})
+
+	task, _ := s.Get(ctx, "cmd", 0, time.Minute)
+	Expect(s.Snooze(ctx, task, time.Now().Add(5*time.Minute))).To(Succeed())
+
+	again, _ := s.Get(ctx, "cmd", 0, time.Minute)
+	Expect(again).To(BeNil(), "a snoozed task should wait until its time")
+
+	s.now = func() time.Time { return time.Now().Add(10 * time.Minute) }
+	task, _ = s.Get(ctx, "cmd", 0, time.Minute)
+	Expect(task).ToNot(BeNil())
+	Expect(task.Attempts).To(Equal(0), "snoozing should not count an attempt")
+
+	Expect(s.Discard(ctx, task)).To(Succeed())
+	Expect(s.Discard(ctx, task)).ToNot(Succeed(), "a discarded task should be gone")
+
+	dead, _ := s.DeadTasks(ctx, "", 10)
+	Expect(dead).To(BeEmpty(), "a discarded task should not be dead")
+
+	child, _ := s.Get(ctx, "cmd", 0, time.Minute)
+	Expect(child).ToNot(BeNil(), "the tasks waiting for a discarded task should be claimable")
+	Expect(child.TaskID).To(Equal("2"))
+
+	created, _ := s.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd"})
+	Expect(created).To(BeTrue(), "a discarded task should let its dedup key go")
+}
+
+func TestReclaimExpiredLeases(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewTaskStorage()
+
+	s.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd"})
+	s.Get(ctx, "cmd", 0, time.Minute)
+
+	n, _ := s.Reclaim(ctx, []string{"cmd"})
+	Expect(n).To(Equal(0), "a live lease should not be reclaimed")
+
+	s.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	n, _ = s.Reclaim(ctx, []string{"cmd"})
+	Expect(n).To(Equal(1))
+
+	for i := 0; i < 2; i++ {
+		s.now = time.Now
+		s.Get(ctx, "cmd", 0, time.Minute)
+
+		s.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+		s.Reclaim(ctx, []string{"cmd"})
+	}
+
+	s.now = time.Now
+	task, _ := s.Get(ctx, "cmd", 0, time.Minute)
+	Expect(task.Attempts).To(Equal(3), "every expired lease should count an attempt")
+	Expect(task.LastError).To(Equal(taskworker.LeaseExpired))
+}
+
+func TestStaleClaimCannotConcludeTask(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewTaskStorage()
+
+	s.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd"})
+	stale, _ := s.Get(ctx, "cmd", 0, time.Minute)
+
+	s.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	s.Reclaim(ctx, []string{"cmd"})
+
+	current, _ := s.Get(ctx, "cmd", 0, time.Minute)
+	Expect(current.ID).To(Equal(stale.ID))
+	Expect(current.LeaseToken).ToNot(Equal(stale.LeaseToken), "a new claim should get a new lease token")
+
+	Expect(s.Extend(ctx, stale, time.Minute)).ToNot(Succeed())
+	Expect(s.Complete(ctx, stale)).ToNot(Succeed())
+	Expect(s.Fail(ctx, stale, "boom", 0)).ToNot(Succeed())
+	Expect(s.Bury(ctx, stale, "boom")).ToNot(Succeed())
+	Expect(s.Snooze(ctx, stale, time.Now())).ToNot(Succeed())
+	Expect(s.Discard(ctx, stale)).ToNot(Succeed())
+	Expect(s.Release(ctx, []*taskworker.Task{stale})).To(Succeed())
+
+	Expect(s.Extend(ctx, current, time.Minute)).To(Succeed(), "the stale release should leave the current claim alone")
+	Expect(s.Complete(ctx, current)).To(Succeed())
+}
+
+func TestDependencies(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewTaskStorage()
+
+	Expect(s.CreateBatch(ctx, []*taskworker.Task{
+		{TaskID: "fetch", Action: "fetch", WorkflowID: "w"},
+		{TaskID: "transform", Action: "transform", WorkflowID: "w", Parents: []string{"fetch"}},
+		{TaskID: "publish", Action: "publish", WorkflowID: "w", Parents: []string{"transform"}},
+	})).To(Equal(3))
+
+	child, _ := s.Get(ctx, "transform", 0, time.Minute)
+	Expect(child).To(BeNil(), "a child should wait for its parents")
+
+	parent, _ := s.Get(ctx, "fetch", 0, time.Minute)
+	Expect(s.Complete(ctx, parent)).To(Succeed())
+
+	child, _ = s.Get(ctx, "transform", 0, time.Minute)
+	Expect(child).ToNot(BeNil(), "a child should be claimable once its parents are done")
+
+	Expect(s.Bury(ctx, child, "boom")).To(Succeed())
+
+	dead, _ := s.DeadTasks(ctx, "publish", 10)
+	Expect(dead).To(HaveLen(1), "the descendants of a dead task should be dead too")
+
+	_, err := s.Create(ctx, &taskworker.Task{TaskID: "notify", Action: "notify", WorkflowID: "w", Parents: []string{"transform"}})
+	Expect(err).To(HaveOccurred(), "a task should not wait for a dead parent")
+}
+
+func TestDependenciesAreScopedToTheirWorkflow(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewTaskStorage()
+
+	Expect(s.CreateBatch(ctx, []*taskworker.Task{{TaskID: "fetch", Action: "fetch", WorkflowID: "a"}})).To(Equal(1))
+	parent, _ := s.Get(ctx, "fetch", 0, time.Minute)
+	Expect(s.Complete(ctx, parent)).To(Succeed())
+
+	Expect(s.CreateBatch(ctx, []*taskworker.Task{
+		{TaskID: "fetch", Action: "fetch", WorkflowID: "b"},
+		{TaskID: "transform", Action: "transform", WorkflowID: "b", Parents: []string{"fetch"}},
+	})).To(Equal(2))
+
+	child, _ := s.Get(ctx, "transform", 0, time.Minute)
+	Expect(child).To(BeNil(), "a task done in another workflow should not satisfy a dependency")
+
+	parent, _ = s.Get(ctx, "fetch", 0, time.Minute)
+	Expect(s.Complete(ctx, parent)).To(Succeed())
+
+	child, _ = s.Get(ctx, "transform", 0, time.Minute)
+	Expect(child).ToNot(BeNil())
+	Expect(child.WorkflowID).To(Equal("b"))
+}
+
+func TestWorkflowTasksAreValidated(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewTaskStorage()
+
+	_, err := s.Create(ctx, &taskworker.Task{TaskID: "2", Action: "cmd", Parents: []string{"1"}})
+	Expect(err).To(HaveOccurred(), "parents should only be looked up within a workflow")
+
+	_, err = s.CreateBatch(ctx, []*taskworker.Task{
+		{TaskID: "1", Action: "email", WorkflowID: "w"},
+		{TaskID: "1", Action: "sms", WorkflowID: "w"},
+	})
+	Expect(err).To(HaveOccurred(), "task ids should be unique within a workflow")
+
+	_, err = s.CreateBatch(ctx, []*taskworker.Task{
+		{TaskID: "1", Action: "cmd", WorkflowID: "w"},
+		{TaskID: "2", Action: "cmd", WorkflowID: "w", Parents: []string{"0"}},
+	})
+	Expect(err).To(HaveOccurred(), "parents should be part of the workflow")
+
+	tasks, _ := s.GetBatch(ctx, []string{"*"}, 0, time.Minute, 10)
+	Expect(tasks).To(BeEmpty(), "a rejected batch should store no task")
+
+	s.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd"})
+	_, err = s.CreateBatch(ctx, []*taskworker.Task{{TaskID: "1", Action: "cmd", WorkflowID: "w"}})
+	Expect(err).To(HaveOccurred(), "a workflow task should not be silently deduplicated")
+
+	created, err := s.CreateBatch(ctx, []*taskworker.Task{{TaskID: "1", Action: "cmd"}, {TaskID: "2", Action: "cmd"}})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(created).To(Equal(1), "a deduplicated task should be skipped and left out of the count")
+}
+
+func TestWorkflowOutlivesCleanup(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewTaskStorage()
+
+	Expect(s.CreateBatch(ctx, []*taskworker.Task{
+		{TaskID: "fetch", Action: "fetch", WorkflowID: "w"},
+		{TaskID: "transform", Action: "transform", WorkflowID: "w", Parents: []string{"fetch"}},
+	})).To(Equal(2))
+
+	parent, _ := s.Get(ctx, "fetch", 0, time.Minute)
+	Expect(s.Complete(ctx, parent)).To(Succeed())
+
+	n, _ := s.Cleanup(ctx, "fetch", 0)
+	Expect(n).To(Equal(1))
+
+	created, err := s.Create(ctx, &taskworker.Task{TaskID: "publish", Action: "publish", WorkflowID: "w", Parents: []string{"fetch"}})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(created).To(BeTrue())
+
+	child, _ := s.Get(ctx, "publish", 0, time.Minute)
+	Expect(child).ToNot(BeNil(), "a task added later should not wait for a parent which was done and cleaned up")
+	Expect(s.Complete(ctx, child)).To(Succeed())
+
+	child, _ = s.Get(ctx, "transform", 0, time.Minute)
+	Expect(s.Bury(ctx, child, "boom")).To(Succeed())
+	s.Cleanup(ctx, "fetch", 0)
+
+	n, _ = s.RequeueAll(ctx, "transform")
+	Expect(n).To(Equal(1))
+
+	child, _ = s.Get(ctx, "transform", 0, time.Minute)
+	Expect(child).ToNot(BeNil(), "a requeued task should not wait for a parent which was done and cleaned up")
+	Expect(s.Complete(ctx, child)).To(Succeed())
+
+	s.Cleanup(ctx, "fetch", 0)
+	Expect(s.steps).To(BeEmpty(), "a workflow should be cleaned up once all of its tasks are done")
+}
+
+func TestGroupCallback(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewTaskStorage()
+
+	group := &taskworker.Group{ID: "import", Callback: &taskworker.Task{TaskID: "import", Action: "done"}, CallbackOnDead: true}
+	Expect(s.CreateGroup(ctx, group, []*taskworker.Task{
+		{TaskID: "1", Action: "part"},
+		{TaskID: "2", Action: "part"},
+	})).To(Succeed())
+
+	first, _ := s.Get(ctx, "part", 0, time.Minute)
+	Expect(s.Complete(ctx, first)).To(Succeed())
+
+	callback, _ := s.Get(ctx, "done", 0, time.Minute)
+	Expect(callback).To(BeNil(), "the callback should wait for every member")
+
+	second, _ := s.Get(ctx, "part", 0, time.Minute)
+	Expect(s.Bury(ctx, second, "boom")).To(Succeed())
+
+	callback, _ = s.Get(ctx, "done", 0, time.Minute)
+	Expect(callback).ToNot(BeNil())
+
+	summary := taskworker.GroupSummary{}
+	Expect(json.Unmarshal(callback.Data.([]byte), &summary)).To(Succeed())
+	Expect(summary.Total).To(Equal(2))
+	Expect(summary.Completed).To(Equal(1))
+	Expect(summary.DeadTaskIDs).To(Equal([]string{second.TaskID}))
+}
+
+func TestDiscardCountsAsCompleted(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	s := NewTaskStorage()
+
+	group := &taskworker.Group{ID: "import", Callback: &taskworker.Task{TaskID: "import", Action: "done"}}
+	Expect(s.CreateGroup(ctx, group, []*taskworker.Task{
+		{TaskID: "1", Action: "part", WorkflowID: "w"},
+	})).To(Succeed())
+	s.Create(ctx, &taskworker.Task{TaskID: "2", Action: "next", WorkflowID: "w", Parents: []string{"1"}})
+
+	part, _ := s.Get(ctx, "part", 0, time.Minute)
+	Expect(s.Discard(ctx, part)).To(Succeed())
+
+	callback, _ := s.Get(ctx, "done", 0, time.Minute)
+	Expect(callback).ToNot(BeNil(), "the callback should fire once the discarded member is counted")
+
+	summary := taskworker.GroupSummary{}
+	Expect(json.Unmarshal(callback.Data.([]byte), &summary)).To(Succeed())
+	Expect(summary.Completed).To(Equal(1))
+	Expect(summary.DeadTaskIDs).To(BeEmpty())
+
+	next, _ := s.Get(ctx, "next", 0, time.Minute)
+	Expect(next).ToNot(BeNil(), "the tasks waiting for a discarded task should be claimable")
+
+	dead, _ := s.DeadTasks(ctx, "", 10)
+	Expect(dead).To(BeEmpty())
+}
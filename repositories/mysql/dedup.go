@@ -0,0 +1,62 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// hold takes the dedup key of a task, unless another task holds it. A key is held while its task is in the 'todo' or
+// 'doing' state and until the dedup window passes after it is completed. Concurrent holders wait on the key's primary
+// key, so only one of them takes it. This relies on the default affected rows reporting, so the connection must not
+// set clientFoundRows.
+func (s *TaskStorage) hold(ctx context.Context, tx *sql.Tx, action string, taskID string) (bool, error) {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO `+s.keyTable+`(action, task_id)
+		VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE expires_at = IF(expires_at <= NOW(6), NULL, expires_at);
+	`, action, taskID)
+
+	if err != nil {
+		return false, errors.Wrap(err, "error occurred holding the dedup key")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "error occurred holding the dedup key")
+	}
+
+	return n > 0, nil
+}
+
+// expire lets the dedup key of a completed task go once the dedup window passes.
+func (s *TaskStorage) expire(ctx context.Context, tx *sql.Tx, action string, taskID string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE `+s.keyTable+`
+		SET expires_at = TIMESTAMPADD(MICROSECOND, ?, NOW(6))
+		WHERE action = ?
+			AND task_id = ?;
+	`, micros(s.window), action, taskID)
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred expiring the dedup key")
+	}
+
+	return nil
+}
+
+// drop lets the dedup key of a dead task go, so the task can be added again.
+func (s *TaskStorage) drop(ctx context.Context, tx *sql.Tx, action string, taskID string) error {
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM `+s.keyTable+`
+		WHERE action = ?
+			AND task_id = ?;
+	`, action, taskID)
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred dropping the dedup key")
+	}
+
+	return nil
+}
@@ -0,0 +1,307 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+// parentsScanner scans a JSON array of parent task IDs.
+type parentsScanner struct {
+	parents *[]string
+}
+
+// Scan implements sql.Scanner.
+func (p parentsScanner) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*p.parents = nil
+
+		return nil
+	case []byte:
+		return json.Unmarshal(src, p.parents)
+	case string:
+		return json.Unmarshal([]byte(src), p.parents)
+	}
+
+	return errors.Errorf("cannot scan %T into the task parents", src)
+}
+
+func encodeParents(parents []string) ([]byte, error) {
+	if parents == nil {
+		parents = []string{}
+	}
+
+	return json.Marshal(parents)
+}
+
+// states locks the steps of the parents of a task within its workflow and returns their states by task ID.
+func (s *TaskStorage) states(ctx context.Context, tx *sql.Tx, workflowID string, parents []string) (map[string]string, error) {
+	states := make(map[string]string, len(parents))
+	if len(parents) == 0 {
+		return states, nil
+	}
+
+	args := make([]interface{}, 0, len(parents)+1)
+	args = append(args, workflowID)
+	for _, parent := range parents {
+		args = append(args, parent)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT task_id, state
+		FROM `+s.stepTable+`
+		WHERE workflow_id = ?
+			AND task_id IN (?`+strings.Repeat(", ?", len(parents)-1)+`)
+		FOR SHARE;
+	`, args...)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred locking the parent tasks")
+	}
+
+	for rows.Next() {
+		var taskID, state string
+		if err := rows.Scan(&taskID, &state); err != nil {
+			rows.Close()
+
+			return nil, errors.Wrap(err, "error occurred locking the parent tasks")
+		}
+
+		states[taskID] = state
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred locking the parent tasks")
+	}
+
+	return states, nil
+}
+
+// waiting counts the parents which are not done yet within the workflow.
+func (s *TaskStorage) waiting(ctx context.Context, tx *sql.Tx, workflowID string, parents []string) (int, error) {
+	states, err := s.states(ctx, tx, workflowID, parents)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := make(map[string]bool, len(parents))
+	for _, parent := range parents {
+		if states[parent] != "done" {
+			pending[parent] = true
+		}
+	}
+
+	return len(pending), nil
+}
+
+// lockParents locks the parents of a task within its workflow, so they are neither done nor dead until the task is
+// stored, and returns how many of them are not done yet. It fails if a parent is not part of the workflow or is dead.
+func (s *TaskStorage) lockParents(ctx context.Context, tx *sql.Tx, task *taskworker.Task) (int, error) {
+	if task.WorkflowID == "" {
+		if len(task.Parents) > 0 {
+			return 0, errors.Errorf("task %s has parents but is not part of a workflow", task.TaskID)
+		}
+
+		return 0, nil
+	}
+
+	states, err := s.states(ctx, tx, task.WorkflowID, task.Parents)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := make(map[string]bool, len(task.Parents))
+	for _, parent := range task.Parents {
+		switch state, ok := states[parent]; {
+		case !ok:
+			return 0, errors.Errorf("parent %s of task %s is not part of workflow %s", parent, task.TaskID, task.WorkflowID)
+		case state == "dead":
+			return 0, errors.Errorf("parent %s of task %s is dead", parent, task.TaskID)
+		case state != "done":
+			pending[parent] = true
+		}
+	}
+
+	return len(pending), nil
+}
+
+// addStep adds a task to its workflow, if any. It fails if the workflow has a task with the same ID already.
+func (s *TaskStorage) addStep(ctx context.Context, tx *sql.Tx, task *taskworker.Task) error {
+	if task.WorkflowID == "" {
+		return nil
+	}
+
+	// an existing step is left as it is, which reports no affected rows
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO `+s.stepTable+`(workflow_id, task_id, action)
+		VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE task_id = task_id;
+	`, task.WorkflowID, task.TaskID, task.Action)
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred adding the task to its workflow")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "error occurred adding the task to its workflow")
+	}
+
+	if n == 0 {
+		return errors.Errorf("task %s is already part of workflow %s", task.TaskID, task.WorkflowID)
+	}
+
+	return nil
+}
+
+// unblock marks a completed task as done within its workflow and counts it on the tasks waiting for it.
+func (s *TaskStorage) unblock(ctx context.Context, tx *sql.Tx, workflowID string, taskID string) error {
+	if workflowID == "" {
+		return nil
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE `+s.stepTable+`
+		SET state = 'done',
+			finished_at = NOW(6)
+		WHERE workflow_id = ?
+			AND task_id = ?
+			AND state <> 'done';
+	`, workflowID, taskID)
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred marking the task as done in its workflow")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "error occurred marking the task as done in its workflow")
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	// locking reads see the latest committed rows, so a child added once the step was let go is counted as well
+	_, err = tx.ExecContext(ctx, `
+		UPDATE `+s.todoTable+`
+		SET pending = pending - 1
+		WHERE workflow_id = ?
+			AND JSON_CONTAINS(parents, JSON_QUOTE(?))
+			AND pending > 0;
+	`, workflowID, taskID)
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred unblocking the child tasks")
+	}
+
+	return nil
+}
+
+// cascade marks a dead task as dead within its workflow and moves every task waiting, directly or not, for it to the
+// 'dead' state.
+func (s *TaskStorage) cascade(ctx context.Context, tx *sql.Tx, workflowID string, taskID string) error {
+	if workflowID == "" {
+		return nil
+	}
+
+	if err := s.markDead(ctx, tx, workflowID, taskID); err != nil {
+		return err
+	}
+
+	reason := fmt.Sprintf("parent task %s is dead", taskID)
+
+	parents := []string{taskID}
+	for len(parents) > 0 {
+		parent := parents[0]
+		parents = parents[1:]
+
+		type child struct {
+			id      int64
+			taskID  string
+			action  string
+			groupID string
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+		SELECT id, task_id, action, group_id
+		FROM `+s.todoTable+`
+		WHERE workflow_id = ?
+			AND JSON_CONTAINS(parents, JSON_QUOTE(?))
+		FOR UPDATE;
+	`, workflowID, parent)
+
+		if err != nil {
+			return errors.Wrap(err, "error occurred burying the child tasks")
+		}
+
+		children := make([]child, 0)
+		for rows.Next() {
+			c := child{}
+			if err := rows.Scan(&c.id, &c.taskID, &c.action, &c.groupID); err != nil {
+				rows.Close()
+
+				return errors.Wrap(err, "error occurred burying the child tasks")
+			}
+
+			children = append(children, c)
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "error occurred burying the child tasks")
+		}
+
+		for _, c := range children {
+			if _, err := tx.ExecContext(ctx, `
+		INSERT INTO `+s.deadTable+`(`+carried+`, attempts, last_error)
+		SELECT `+carried+`, attempts, ?
+		FROM `+s.todoTable+`
+		WHERE id = ?;
+	`, reason, c.id); err != nil {
+				return errors.Wrap(err, "error occurred burying the child tasks")
+			}
+
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+s.todoTable+` WHERE id = ?;`, c.id); err != nil {
+				return errors.Wrap(err, "error occurred burying the child tasks")
+			}
+
+			if err := s.drop(ctx, tx, c.action, c.taskID); err != nil {
+				return err
+			}
+
+			if err := s.markDead(ctx, tx, workflowID, c.taskID); err != nil {
+				return err
+			}
+
+			if err := s.settle(ctx, tx, c.groupID, 0, 1); err != nil {
+				return err
+			}
+
+			parents = append(parents, c.taskID)
+		}
+	}
+
+	return nil
+}
+
+// markDead marks a task as dead within its workflow.
+func (s *TaskStorage) markDead(ctx context.Context, tx *sql.Tx, workflowID string, taskID string) error {
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE `+s.stepTable+`
+		SET state = 'dead'
+		WHERE workflow_id = ?
+			AND task_id = ?;
+	`, workflowID, taskID); err != nil {
+		return errors.Wrap(err, "error occurred marking the task as dead in its workflow")
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/psimoesSsimoes/go-task-fanout/repositories/transaction"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+// CreateGroup stores the tasks of a group for processing, either all of them or none. Completing or burying the last
+// member adds the group callback.
+func (s *TaskStorage) CreateGroup(ctx context.Context, group *taskworker.Group, tasks []*taskworker.Task) error {
+	data, err := json.Marshal(group.Callback.Data)
+	if err != nil {
+		return errors.Wrap(err, "error occurred creating the group")
+	}
+
+	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `
+		INSERT INTO `+s.groupTable+`(group_id, total, callback_task_id, callback_action, callback_data, callback_priority, callback_on_dead)
+		VALUES (?, 0, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE group_id = group_id;
+	`, group.ID, group.Callback.TaskID, group.Callback.Action, data, group.Callback.Priority, group.CallbackOnDead)
+
+		if err != nil {
+			return errors.Wrap(err, "error occurred creating the group")
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return errors.New("group already exists")
+		}
+
+		total := 0
+		for _, task := range tasks {
+			task.GroupID = group.ID
+
+			inserted, err := s.insert(ctx, tx, task)
+			if err != nil {
+				return err
+			}
+
+			if inserted {
+				total++
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `UPDATE `+s.groupTable+` SET total = ? WHERE group_id = ?;`, total, group.ID); err != nil {
+			return errors.Wrap(err, "error occurred creating the group")
+		}
+
+		// a group whose members were all deduplicated is finished already
+		return s.settle(ctx, tx, group.ID, 0, 0)
+	})
+}
+
+// settle counts a finished member of a group and adds the group callback once every member is finished.
+func (s *TaskStorage) settle(ctx context.Context, tx *sql.Tx, groupID string, completed int, dead int) error {
+	if groupID == "" {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE `+s.groupTable+`
+		SET completed = completed + ?,
+			dead = dead + ?
+		WHERE group_id = ?;
+	`, completed, dead, groupID); err != nil {
+		return errors.Wrap(err, "error occurred settling the group")
+	}
+
+	summary := taskworker.GroupSummary{GroupID: groupID}
+	callback := &taskworker.Task{}
+	var data []byte
+	var onDead, fired bool
+
+	err := tx.QueryRowContext(ctx, `
+		SELECT total, completed, dead, callback_task_id, callback_action, callback_data, callback_priority,
+			callback_on_dead, fired_at IS NOT NULL
+		FROM `+s.groupTable+`
+		WHERE group_id = ?;
+	`, groupID).Scan(
+		&summary.Total,
+		&summary.Completed,
+		&summary.Dead,
+		&callback.TaskID,
+		&callback.Action,
+		&data,
+		&callback.Priority,
+		&onDead,
+		&fired,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+
+		return errors.Wrap(err, "error occurred settling the group")
+	}
+
+	summary.Data = data
+
+	if fired || summary.Completed+summary.Dead < summary.Total || (summary.Dead > 0 && !onDead) {
+		return nil
+	}
+
+	if summary.Dead > 0 {
+		rows, err := tx.QueryContext(ctx, `SELECT task_id FROM `+s.deadTable+` WHERE group_id = ?;`, groupID)
+		if err != nil {
+			return errors.Wrap(err, "error occurred settling the group")
+		}
+
+		for rows.Next() {
+			var taskID string
+			if err := rows.Scan(&taskID); err != nil {
+				rows.Close()
+
+				return errors.Wrap(err, "error occurred settling the group")
+			}
+
+			summary.DeadTaskIDs = append(summary.DeadTaskIDs, taskID)
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "error occurred settling the group")
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE `+s.groupTable+` SET fired_at = NOW(6) WHERE group_id = ?;`, groupID); err != nil {
+		return errors.Wrap(err, "error occurred settling the group")
+	}
+
+	callback.Data = summary
+	if _, err := s.insert(ctx, tx, callback); err != nil {
+		return errors.Wrap(err, "error occurred adding the group callback")
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/psimoesSsimoes/go-task-fanout/models"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+const (
+	// subject is the subject of the tasks managed through the interactors.
+	subject = "tasks"
+	// batchSize is how many tasks GetSeveralTasks claims at most.
+	batchSize = 1000
+	// lease is for how long tasks claimed through the interactors are reserved before they can be reclaimed.
+	lease = 5 * time.Minute
+)
+
+// TaskDispatcher implements interactors.TaskDispatcherRepository on top of the MySQL task storage.
+type TaskDispatcher struct {
+	storage TaskStorage
+}
+
+// NewTaskDispatcher factory method to create a new repository instance
+func NewTaskDispatcher(pool *sql.DB) TaskDispatcher {
+	return TaskDispatcher{NewTaskStorage(pool, subject)}
+}
+
+// CreateSchema creates the task tables.
+func (r *TaskDispatcher) CreateSchema(ctx context.Context) error {
+	return r.storage.Init(ctx)
+}
+
+// CreateTask stores a new task in the 'todo' state. Tasks which are already waiting or running are ignored.
+func (r *TaskDispatcher) CreateTask(ctx context.Context, task models.Task) error {
+	_, err := r.storage.Create(ctx, &taskworker.Task{
+		TaskID: task.TaskID,
+		Action: task.Action,
+		Data:   task.Data,
+	})
+
+	return err
+}
+
+// TaskRegisterUpdater implements interactors.TaskRegisterRepository on top of the MySQL task storage.
+type TaskRegisterUpdater struct {
+	storage TaskStorage
+}
+
+// NewTaskRegisterUpdater factory method to create a new repository instance
+func NewTaskRegisterUpdater(pool *sql.DB) TaskRegisterUpdater {
+	return TaskRegisterUpdater{NewTaskStorage(pool, subject)}
+}
+
+// CreateSchema creates the task tables.
+func (r *TaskRegisterUpdater) CreateSchema(ctx context.Context) error {
+	return r.storage.Init(ctx)
+}
+
+// GetTask moves the next task for action from the 'todo' to the 'doing' state. It returns an empty task if there is none.
+func (r *TaskRegisterUpdater) GetTask(ctx context.Context, action string, age time.Duration) (models.Task, error) {
+	task, err := r.storage.Get(ctx, action, age, lease)
+	if err != nil || task == nil {
+		return models.Task{}, err
+	}
+
+	return toModel(task), nil
+}
+
+// GetSeveralTasks moves the next tasks for action from the 'todo' to the 'doing' state.
+func (r *TaskRegisterUpdater) GetSeveralTasks(ctx context.Context, action string, age time.Duration) ([]models.Task, error) {
+	tasks, err := r.storage.GetBatch(ctx, []string{action}, age, lease, batchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]models.Task, 0, len(tasks))
+	for _, task := range tasks {
+		result = append(result, toModel(task))
+	}
+
+	return result, nil
+}
+
+// MarkAsDone moves a task from the 'doing' to the 'done' state.
+func (r *TaskRegisterUpdater) MarkAsDone(ctx context.Context, task models.Task) error {
+	claimed := &taskworker.Task{}
+
+	row := r.storage.pool.QueryRowContext(ctx, `
+		SELECT id, lease_token
+		FROM `+r.storage.doingTable+`
+		WHERE action = ?
+			AND task_id = ?;
+	`, task.Action, task.TaskID)
+
+	if err := row.Scan(&claimed.ID, &claimed.LeaseToken); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("task is no longer in the doing state")
+		}
+
+		return errors.Wrap(err, "error occurred completing the task")
+	}
+
+	return r.storage.Complete(ctx, claimed)
+}
+
+// MarkSeveralAsDone moves several tasks from the 'doing' to the 'done' state.
+func (r *TaskRegisterUpdater) MarkSeveralAsDone(ctx context.Context, tasks []models.Task) error {
+	for _, task := range tasks {
+		if err := r.MarkAsDone(ctx, task); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func toModel(task *taskworker.Task) models.Task {
+	return models.Task{
+		TaskID:    task.TaskID,
+		Data:      task.Data,
+		Action:    task.Action,
+		CreatedAt: task.CreatedAt,
+		StartedAt: task.StartedAt,
+	}
+}
@@ -0,0 +1,1100 @@
+package mysql
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/psimoesSsimoes/go-task-fanout/repositories/transaction"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+// carried are the columns a task keeps while it moves between the todo, doing, done and dead tables.
+const carried = "id, task_id, action, data, created_at, priority, group_id, workflow_id, parents, timeout_ms"
+
+// TaskStorage manages tasks on MySQL 8 or later. Tables are created in the database of the connection. Since MySQL has
+// no LISTEN/NOTIFY, receivers only find new tasks on their tick.
+type TaskStorage struct {
+	pool       *sql.DB
+	subject    string
+	todoTable  string
+	doingTable string
+	doneTable  string
+	deadTable  string
+	groupTable string
+	keyTable   string
+	stepTable  string
+	window     time.Duration
+}
+
+// StorageOption is the abstract functional-parameter type used for storage configuration.
+type StorageOption func(*TaskStorage)
+
+// WithDedupWindow allows you to keep deduplicating a task for the given duration after it is completed. By default a
+// task is only deduplicated while it is in the 'todo' or 'doing' state.
+func WithDedupWindow(window time.Duration) StorageOption {
+	return func(s *TaskStorage) {
+		if window >= 0 {
+			s.window = window
+		}
+	}
+}
+
+// NewTaskStorage creates a task storage
+func NewTaskStorage(conn *sql.DB, subject string, opts ...StorageOption) TaskStorage {
+	s := TaskStorage{
+		pool:       conn,
+		subject:    subject,
+		todoTable:  fmt.Sprintf("%s_todo", subject),
+		doingTable: fmt.Sprintf("%s_doing", subject),
+		doneTable:  fmt.Sprintf("%s_done", subject),
+		deadTable:  fmt.Sprintf("%s_dead", subject),
+		groupTable: fmt.Sprintf("%s_groups", subject),
+		keyTable:   fmt.Sprintf("%s_keys", subject),
+		stepTable:  fmt.Sprintf("%s_steps", subject),
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return s
+}
+
+// Init prepares the storage, if needed, to manage task to a specific subject.
+func (s *TaskStorage) Init(ctx context.Context) error {
+	// identifiers are compared byte by byte, as in the other storages, rather than with the default case and accent
+	// insensitive collation
+	statements := []string{`
+		CREATE TABLE IF NOT EXISTS ` + s.todoTable + `
+		(
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			task_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			action VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			data JSON,
+			created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			priority INT NOT NULL DEFAULT 0,
+			group_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '',
+			workflow_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '',
+			parents JSON NOT NULL,
+			timeout_ms BIGINT NOT NULL DEFAULT 0,
+			pending INT NOT NULL DEFAULT 0,
+			run_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			next_run_at DATETIME(6),
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			INDEX claim_idx (action, priority DESC, created_at ASC)
+		);`, `
+		CREATE TABLE IF NOT EXISTS ` + s.doingTable + `
+		(
+			id BIGINT PRIMARY KEY,
+			task_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			action VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			data JSON,
+			created_at DATETIME(6) NOT NULL,
+			priority INT NOT NULL DEFAULT 0,
+			group_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '',
+			workflow_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '',
+			parents JSON NOT NULL,
+			timeout_ms BIGINT NOT NULL DEFAULT 0,
+			started_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			lease_expires_at DATETIME(6),
+			lease_token VARCHAR(64),
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			INDEX lease_idx (action, lease_expires_at)
+		);`, `
+		CREATE TABLE IF NOT EXISTS ` + s.doneTable + `
+		(
+			id BIGINT PRIMARY KEY,
+			task_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			action VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			data JSON,
+			created_at DATETIME(6) NOT NULL,
+			priority INT NOT NULL DEFAULT 0,
+			group_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '',
+			workflow_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '',
+			parents JSON NOT NULL,
+			timeout_ms BIGINT NOT NULL DEFAULT 0,
+			started_at DATETIME(6),
+			attempts INT NOT NULL DEFAULT 0,
+			finished_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			result JSON,
+			INDEX finished_idx (action, finished_at),
+			INDEX result_idx (action, task_id, finished_at)
+		);`, `
+		CREATE TABLE IF NOT EXISTS ` + s.deadTable + `
+		(
+			id BIGINT PRIMARY KEY,
+			task_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			action VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			data JSON,
+			created_at DATETIME(6) NOT NULL,
+			priority INT NOT NULL DEFAULT 0,
+			group_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '',
+			workflow_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL DEFAULT '',
+			parents JSON NOT NULL,
+			timeout_ms BIGINT NOT NULL DEFAULT 0,
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			dead_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			INDEX group_idx (group_id)
+		);`, `
+		CREATE TABLE IF NOT EXISTS ` + s.groupTable + `
+		(
+			group_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin PRIMARY KEY,
+			total INT NOT NULL,
+			completed INT NOT NULL DEFAULT 0,
+			dead INT NOT NULL DEFAULT 0,
+			callback_task_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			callback_action VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			callback_data JSON,
+			callback_priority INT NOT NULL DEFAULT 0,
+			callback_on_dead BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			fired_at DATETIME(6)
+		);`, `
+		CREATE TABLE IF NOT EXISTS ` + s.keyTable + `
+		(
+			action VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			task_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			expires_at DATETIME(6),
+			PRIMARY KEY (action, task_id)
+		);`, `
+		CREATE TABLE IF NOT EXISTS ` + s.stepTable + `
+		(
+			workflow_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			task_id VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			action VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			state VARCHAR(16) NOT NULL DEFAULT 'waiting',
+			finished_at DATETIME(6),
+			PRIMARY KEY (workflow_id, task_id)
+		);`,
+	}
+
+	// MySQL commits DDL implicitly, so the statements are not run in a transaction
+	for _, statement := range statements {
+		if _, err := s.pool.ExecContext(ctx, statement); err != nil {
+			return errors.Wrap(err, "error occurred creating the task tables")
+		}
+	}
+
+	return nil
+}
+
+// Create stores a task for processing. It returns false if the task was deduplicated.
+func (s *TaskStorage) Create(ctx context.Context, task *taskworker.Task) (bool, error) {
+	var inserted bool
+
+	err := transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		inserted, err = s.insert(ctx, tx, task)
+
+		return err
+	})
+
+	return inserted, err
+}
+
+// CreateBatch stores several tasks for processing, either all of them or none, and returns how many were stored. Tasks
+// which are deduplicated are skipped rather than failing the batch.
+func (s *TaskStorage) CreateBatch(ctx context.Context, tasks []*taskworker.Task) (int, error) {
+	var count int
+
+	err := transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		for _, task := range tasks {
+			inserted, err := s.insert(ctx, tx, task)
+			if err != nil {
+				return err
+			}
+
+			if inserted {
+				count++
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// insert stores a task in the 'todo' state, unless a task with the same action and ID holds its dedup key. It returns
+// whether the task was stored.
+func (s *TaskStorage) insert(ctx context.Context, tx *sql.Tx, task *taskworker.Task) (bool, error) {
+	data, err := json.Marshal(task.Data)
+	if err != nil {
+		return false, errors.Wrap(err, "error occurred creating the task")
+	}
+
+	held, err := s.hold(ctx, tx, task.Action, task.TaskID)
+	if err != nil {
+		return false, err
+	}
+
+	if !held {
+		if task.WorkflowID != "" {
+			return false, errors.Errorf("task %s of workflow %s is already waiting or running", task.TaskID, task.WorkflowID)
+		}
+
+		return false, nil
+	}
+
+	parents, err := encodeParents(task.Parents)
+	if err != nil {
+		return false, errors.Wrap(err, "error occurred creating the task")
+	}
+
+	pending, err := s.lockParents(ctx, tx, task)
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.addStep(ctx, tx, task); err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO `+s.todoTable+`(task_id, action, data, run_at, priority, group_id, workflow_id, parents, pending, timeout_ms)
+		VALUES (?, ?, ?, TIMESTAMPADD(MICROSECOND, ?, NOW(6)), ?, ?, ?, ?, ?, ?);
+	`, task.TaskID, task.Action, data, micros(delayUntil(task.RunAt)), task.Priority, task.GroupID, task.WorkflowID, parents, pending, int64(task.Timeout/time.Millisecond))
+
+	if err != nil {
+		return false, errors.Wrap(err, "error occurred creating the task")
+	}
+
+	return true, nil
+}
+
+// Get returns the next Task for command which is in the 'todo' state, leasing it for the given duration.
+func (s *TaskStorage) Get(ctx context.Context, action string, age time.Duration, lease time.Duration) (*taskworker.Task, error) {
+	tasks, err := s.GetBatch(ctx, []string{action}, age, lease, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tasks) == 0 {
+		return nil, nil
+	}
+
+	return tasks[0], nil
+}
+
+// GetBatch returns the next N Tasks matching any of the action patterns which are in the 'todo' state, leasing them
+// for the given duration. Rows locked by a concurrent claim are skipped, so concurrent receivers never block each
+// other nor claim the same task. The tasks share a new lease token, which every later transition must present.
+func (s *TaskStorage) GetBatch(ctx context.Context, actions []string, age time.Duration, lease time.Duration, n int) ([]*taskworker.Task, error) {
+	token, err := newLeaseToken()
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*taskworker.Task, 0)
+
+	err = transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		condition, args := matching(actions)
+
+		ids, err := s.ids(ctx, tx, `
+		SELECT id
+		FROM `+s.todoTable+`
+		WHERE `+condition+`
+			AND created_at <= TIMESTAMPADD(MICROSECOND, ?, NOW(6))
+			AND run_at <= NOW(6)
+			AND (next_run_at IS NULL OR next_run_at <= NOW(6))
+			AND pending = 0
+		ORDER BY priority DESC, created_at ASC
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED;
+	`, append(args, -micros(age), n)...)
+
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		in, args := list(ids)
+
+		if _, err := tx.ExecContext(ctx, `
+		INSERT INTO `+s.doingTable+`(`+carried+`, lease_expires_at, lease_token, attempts, last_error)
+		SELECT `+carried+`, TIMESTAMPADD(MICROSECOND, ?, NOW(6)), ?, attempts, last_error
+		FROM `+s.todoTable+`
+		WHERE id IN (`+in+`);
+	`, append([]interface{}{micros(lease), token}, args...)...); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+s.todoTable+` WHERE id IN (`+in+`);`, args...); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+		SELECT `+carried+`, started_at, lease_expires_at, lease_token, attempts, COALESCE(last_error, '')
+		FROM `+s.doingTable+`
+		WHERE id IN (`+in+`)
+		ORDER BY priority DESC, created_at ASC;
+	`, args...)
+
+		if err != nil {
+			return err
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			task := &taskworker.Task{}
+
+			if err := rows.Scan(
+				&task.ID,
+				&task.TaskID,
+				&task.Action,
+				&task.Data,
+				&task.CreatedAt,
+				&task.Priority,
+				&task.GroupID,
+				&task.WorkflowID,
+				parentsScanner{&task.Parents},
+				durationScanner{&task.Timeout},
+				&task.StartedAt,
+				&task.LeaseExpiresAt,
+				&task.LeaseToken,
+				&task.Attempts,
+				&task.LastError,
+			); err != nil {
+				return err
+			}
+
+			tasks = append(tasks, task)
+		}
+
+		return rows.Err()
+	})
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred getting tasks")
+	}
+
+	return tasks, nil
+}
+
+// Extend pushes the lease of a task in the 'doing' state to the given duration from now.
+func (s *TaskStorage) Extend(ctx context.Context, task *taskworker.Task, lease time.Duration) error {
+	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+		UPDATE `+s.doingTable+`
+		SET lease_expires_at = TIMESTAMPADD(MICROSECOND, ?, NOW(6))
+		WHERE id = ?
+			AND lease_token = ?;
+	`, micros(lease), task.ID, task.LeaseToken); err != nil {
+			return errors.Wrap(err, "error occurred extending the task lease")
+		}
+
+		row := tx.QueryRowContext(ctx, `
+		SELECT lease_expires_at
+		FROM `+s.doingTable+`
+		WHERE id = ?
+			AND lease_token = ?;
+	`, task.ID, task.LeaseToken)
+		if err := row.Scan(&task.LeaseExpiresAt); err != nil {
+			if err == sql.ErrNoRows {
+				return errors.New("task is no longer leased")
+			}
+
+			return errors.Wrap(err, "error occurred extending the task lease")
+		}
+
+		return nil
+	})
+}
+
+// Reclaim moves all tasks matching any of the action patterns in the 'doing' state whose lease has expired back to the
+// 'todo' state, counting a failed attempt with LeaseExpired as its error.
+func (s *TaskStorage) Reclaim(ctx context.Context, actions []string) (int, error) {
+	condition, args := matching(actions)
+
+	n, err := s.reopen(ctx, taskworker.LeaseExpired, condition+` AND lease_expires_at < NOW(6)`, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "error occurred reclaiming tasks")
+	}
+
+	return n, nil
+}
+
+// Release moves tasks which were claimed but never handled back to the 'todo' state.
+func (s *TaskStorage) Release(ctx context.Context, tasks []*taskworker.Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	conditions := make([]string, 0, len(tasks))
+	args := make([]interface{}, 0, 2*len(tasks))
+	for _, task := range tasks {
+		conditions = append(conditions, "(id = ? AND lease_token = ?)")
+		args = append(args, task.ID, task.LeaseToken)
+	}
+
+	if _, err := s.reopen(ctx, "", "("+strings.Join(conditions, " OR ")+")", args...); err != nil {
+		return errors.Wrap(err, "error occurred releasing the tasks")
+	}
+
+	return nil
+}
+
+// Retry moves all tasks for command in the 'doing' state started more than 'age' ago whose lease has expired back to
+// the 'todo' state, counting a failed attempt with LeaseExpired as its error. Tasks whose lease is still being extended
+// are left to their handler.
+func (s *TaskStorage) Retry(ctx context.Context, command string, age time.Duration) (int, error) {
+	n, err := s.reopen(ctx, taskworker.LeaseExpired, `
+			action = ?
+			AND started_at <= TIMESTAMPADD(MICROSECOND, ?, NOW(6))
+			AND lease_expires_at < NOW(6)`, command, -micros(age))
+
+	if err != nil {
+		return 0, errors.Wrap(err, "error occurred retrying stale tasks")
+	}
+
+	return n, nil
+}
+
+// reopen moves the tasks in the 'doing' state matching the condition back to the 'todo' state. A reason counts a failed
+// attempt with it as the error.
+func (s *TaskStorage) reopen(ctx context.Context, reason string, condition string, args ...interface{}) (int, error) {
+	var count int
+
+	err := transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		ids, err := s.ids(ctx, tx, `SELECT id FROM `+s.doingTable+` WHERE `+condition+` FOR UPDATE SKIP LOCKED;`, args...)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		in, idArgs := list(ids)
+
+		if _, err := tx.ExecContext(ctx, `
+		INSERT INTO `+s.todoTable+`(`+carried+`, attempts, last_error)
+		SELECT `+carried+`, attempts + IF(? = '', 0, 1), IF(? = '', last_error, ?)
+		FROM `+s.doingTable+`
+		WHERE id IN (`+in+`);
+	`, append([]interface{}{reason, reason, reason}, idArgs...)...); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+s.doingTable+` WHERE id IN (`+in+`);`, idArgs...); err != nil {
+			return err
+		}
+
+		count = len(ids)
+
+		return nil
+	})
+
+	return count, err
+}
+
+// Cleanup removes all tasks for command in the 'done' state finished more than 'age' ago, along with the workflows
+// involving command whose tasks were all done by then.
+func (s *TaskStorage) Cleanup(ctx context.Context, command string, age time.Duration) (int, error) {
+	res, err := s.pool.ExecContext(ctx, `
+		DELETE FROM `+s.doneTable+`
+		WHERE action = ?
+			AND finished_at <= TIMESTAMPADD(MICROSECOND, ?, NOW(6));
+	`, command, -micros(age))
+
+	if err != nil {
+		return 0, errors.Wrap(err, "error occurred cleaning up tasks")
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "error occurred cleaning up tasks")
+	}
+
+	if _, err := s.pool.ExecContext(ctx, `
+		DELETE FROM `+s.keyTable+`
+		WHERE action = ?
+			AND expires_at <= NOW(6);
+	`, command); err != nil {
+		return 0, errors.Wrap(err, "error occurred cleaning up dedup keys")
+	}
+
+	// a workflow is kept while any of its tasks may still wait for the others
+	if _, err := s.pool.ExecContext(ctx, `
+		DELETE FROM `+s.stepTable+`
+		WHERE workflow_id IN (
+			SELECT workflow_id
+			FROM (
+				SELECT workflow_id
+				FROM `+s.stepTable+`
+				GROUP BY workflow_id
+				HAVING MAX(action = ?) = 1
+					AND MIN(state = 'done' AND finished_at <= TIMESTAMPADD(MICROSECOND, ?, NOW(6))) = 1
+			) AS finished
+		);
+	`, command, -micros(age)); err != nil {
+		return 0, errors.Wrap(err, "error occurred cleaning up workflows")
+	}
+
+	return int(count), nil
+}
+
+// Result returns the last task for action with the given task ID in the 'done' state, with its result, or nil if there
+// is none.
+func (s *TaskStorage) Result(ctx context.Context, action string, taskID string) (*taskworker.Task, error) {
+	row := s.pool.QueryRowContext(ctx, `
+		SELECT `+carried+`, started_at, attempts, finished_at, result
+		FROM `+s.doneTable+`
+		WHERE action = ?
+			AND task_id = ?
+		ORDER BY finished_at DESC
+		LIMIT 1;
+	`, action, taskID)
+
+	task := &taskworker.Task{}
+
+	var result []byte
+	if err := row.Scan(
+		&task.ID,
+		&task.TaskID,
+		&task.Action,
+		&task.Data,
+		&task.CreatedAt,
+		&task.Priority,
+		&task.GroupID,
+		&task.WorkflowID,
+		parentsScanner{&task.Parents},
+		durationScanner{&task.Timeout},
+		&task.StartedAt,
+		&task.Attempts,
+		&task.FinishedAt,
+		&result,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "error occurred getting the task result")
+	}
+
+	if result != nil {
+		task.Result = json.RawMessage(result)
+	}
+
+	return task, nil
+}
+
+// Complete moves a task to the 'done' state, storing its result.
+func (s *TaskStorage) Complete(ctx context.Context, task *taskworker.Task) error {
+	// a task without a result stores NULL rather than an empty document, which is not valid JSON
+	var result interface{}
+	if task.Result != nil {
+		encoded, err := json.Marshal(task.Result)
+		if err != nil {
+			return errors.Wrap(err, "error occurred encoding the task result")
+		}
+
+		result = string(encoded)
+	}
+
+	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		if err := s.lock(ctx, tx, task); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+		INSERT INTO `+s.doneTable+`(`+carried+`, started_at, attempts, result)
+		SELECT `+carried+`, started_at, attempts, ?
+		FROM `+s.doingTable+`
+		WHERE id = ?;
+	`, result, task.ID); err != nil {
+			return errors.Wrap(err, "error occurred completing the task")
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+s.doingTable+` WHERE id = ?;`, task.ID); err != nil {
+			return errors.Wrap(err, "error occurred completing the task")
+		}
+
+		row := tx.QueryRowContext(ctx, `SELECT finished_at FROM `+s.doneTable+` WHERE id = ?;`, task.ID)
+		if err := row.Scan(&task.FinishedAt); err != nil {
+			return errors.Wrap(err, "error occurred completing the task")
+		}
+
+		if err := s.expire(ctx, tx, task.Action, task.TaskID); err != nil {
+			return err
+		}
+
+		if err := s.settle(ctx, tx, task.GroupID, 1, 0); err != nil {
+			return err
+		}
+
+		return s.unblock(ctx, tx, task.WorkflowID, task.TaskID)
+	})
+}
+
+// Fail moves the task back to the 'todo' state, increasing its attempts count, so it is claimable again after the delay.
+func (s *TaskStorage) Fail(ctx context.Context, task *taskworker.Task, reason string, delay time.Duration) error {
+	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		if err := s.lock(ctx, tx, task); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+		INSERT INTO `+s.todoTable+`(`+carried+`, attempts, last_error, next_run_at)
+		SELECT `+carried+`, attempts + 1, ?, TIMESTAMPADD(MICROSECOND, ?, NOW(6))
+		FROM `+s.doingTable+`
+		WHERE id = ?;
+	`, reason, micros(delay), task.ID); err != nil {
+			return errors.Wrap(err, "error occurred failing the task")
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+s.doingTable+` WHERE id = ?;`, task.ID); err != nil {
+			return errors.Wrap(err, "error occurred failing the task")
+		}
+
+		row := tx.QueryRowContext(ctx, `SELECT attempts, next_run_at FROM `+s.todoTable+` WHERE id = ?;`, task.ID)
+		if err := row.Scan(&task.Attempts, &task.NextRunAt); err != nil {
+			return errors.Wrap(err, "error occurred failing the task")
+		}
+
+		task.LastError = reason
+
+		return nil
+	})
+}
+
+// Bury moves the task to the 'dead' state, where it stays until it is requeued or purged.
+func (s *TaskStorage) Bury(ctx context.Context, task *taskworker.Task, reason string) error {
+	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		if err := s.lock(ctx, tx, task); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+		INSERT INTO `+s.deadTable+`(`+carried+`, attempts, last_error)
+		SELECT `+carried+`, attempts + 1, ?
+		FROM `+s.doingTable+`
+		WHERE id = ?;
+	`, reason, task.ID); err != nil {
+			return errors.Wrap(err, "error occurred burying the task")
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+s.doingTable+` WHERE id = ?;`, task.ID); err != nil {
+			return errors.Wrap(err, "error occurred burying the task")
+		}
+
+		row := tx.QueryRowContext(ctx, `SELECT attempts, dead_at FROM `+s.deadTable+` WHERE id = ?;`, task.ID)
+		if err := row.Scan(&task.Attempts, &task.DeadAt); err != nil {
+			return errors.Wrap(err, "error occurred burying the task")
+		}
+
+		task.LastError = reason
+
+		if err := s.drop(ctx, tx, task.Action, task.TaskID); err != nil {
+			return err
+		}
+
+		if err := s.settle(ctx, tx, task.GroupID, 0, 1); err != nil {
+			return err
+		}
+
+		return s.cascade(ctx, tx, task.WorkflowID, task.TaskID)
+	})
+}
+
+// Snooze moves the task back to the 'todo' state without counting an attempt, so it is claimable again once until passes.
+func (s *TaskStorage) Snooze(ctx context.Context, task *taskworker.Task, until time.Time) error {
+	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		if err := s.lock(ctx, tx, task); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+		INSERT INTO `+s.todoTable+`(`+carried+`, attempts, last_error, next_run_at)
+		SELECT `+carried+`, attempts, last_error, TIMESTAMPADD(MICROSECOND, ?, NOW(6))
+		FROM `+s.doingTable+`
+		WHERE id = ?;
+	`, micros(delayUntil(until)), task.ID); err != nil {
+			return errors.Wrap(err, "error occurred snoozing the task")
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+s.doingTable+` WHERE id = ?;`, task.ID); err != nil {
+			return errors.Wrap(err, "error occurred snoozing the task")
+		}
+
+		row := tx.QueryRowContext(ctx, `SELECT next_run_at FROM `+s.todoTable+` WHERE id = ?;`, task.ID)
+		if err := row.Scan(&task.NextRunAt); err != nil {
+			return errors.Wrap(err, "error occurred snoozing the task")
+		}
+
+		return nil
+	})
+}
+
+// Discard removes the task without moving it to the 'done' nor the 'dead' state. It counts as completed both for its
+// group and for the tasks waiting for it, since the work it stood for is no longer needed.
+func (s *TaskStorage) Discard(ctx context.Context, task *taskworker.Task) error {
+	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		if err := s.lock(ctx, tx, task); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+s.doingTable+` WHERE id = ?;`, task.ID); err != nil {
+			return errors.Wrap(err, "error occurred discarding the task")
+		}
+
+		if err := s.drop(ctx, tx, task.Action, task.TaskID); err != nil {
+			return err
+		}
+
+		if err := s.settle(ctx, tx, task.GroupID, 1, 0); err != nil {
+			return err
+		}
+
+		return s.unblock(ctx, tx, task.WorkflowID, task.TaskID)
+	})
+}
+
+// lock locks a task in the 'doing' state, as long as it is still held by the claim of its lease token, and refreshes
+// the fields other operations depend on.
+func (s *TaskStorage) lock(ctx context.Context, tx *sql.Tx, task *taskworker.Task) error {
+	row := tx.QueryRowContext(ctx, `
+		SELECT task_id, action, group_id, workflow_id
+		FROM `+s.doingTable+`
+		WHERE id = ?
+			AND lease_token = ?
+		FOR UPDATE;
+	`, task.ID, task.LeaseToken)
+
+	if err := row.Scan(&task.TaskID, &task.Action, &task.GroupID, &task.WorkflowID); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("task is no longer in the doing state")
+		}
+
+		return errors.Wrap(err, "error occurred locking the task")
+	}
+
+	return nil
+}
+
+// DeadTasks returns up to N tasks in the 'dead' state for action, most recent first. An empty action matches all actions.
+func (s *TaskStorage) DeadTasks(ctx context.Context, action string, n int) ([]*taskworker.Task, error) {
+	rows, err := s.pool.QueryContext(ctx, `
+		SELECT `+carried+`, attempts, COALESCE(last_error, ''), dead_at
+		FROM `+s.deadTable+`
+		WHERE ? = '' OR action = ?
+		ORDER BY dead_at DESC
+		LIMIT ?;
+	`, action, action, n)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred getting dead tasks")
+	}
+
+	defer rows.Close()
+
+	tasks := make([]*taskworker.Task, 0)
+
+	for rows.Next() {
+		task := &taskworker.Task{}
+
+		if err := rows.Scan(
+			&task.ID,
+			&task.TaskID,
+			&task.Action,
+			&task.Data,
+			&task.CreatedAt,
+			&task.Priority,
+			&task.GroupID,
+			&task.WorkflowID,
+			parentsScanner{&task.Parents},
+			durationScanner{&task.Timeout},
+			&task.Attempts,
+			&task.LastError,
+			&task.DeadAt,
+		); err != nil {
+			return nil, errors.Wrap(err, "error occurred getting dead tasks")
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred getting dead tasks")
+	}
+
+	return tasks, nil
+}
+
+// Requeue moves a task in the 'dead' state back to the 'todo' state with its attempts reset.
+func (s *TaskStorage) Requeue(ctx context.Context, id int) error {
+	n, err := s.requeue(ctx, "id = ?", id)
+	if err != nil {
+		return errors.Wrap(err, "error occurred requeuing the task")
+	}
+
+	if n == 0 {
+		return errors.New("task is not in the dead state")
+	}
+
+	return nil
+}
+
+// RequeueAll moves all tasks for action in the 'dead' state back to the 'todo' state. An empty action matches all actions.
+func (s *TaskStorage) RequeueAll(ctx context.Context, action string) (int, error) {
+	n, err := s.requeue(ctx, "? = '' OR action = ?", action, action)
+	if err != nil {
+		return 0, errors.Wrap(err, "error occurred requeuing tasks")
+	}
+
+	return n, nil
+}
+
+// requeue moves the tasks in the 'dead' state matching the condition back to the 'todo' state. Requeued members of
+// groups which did not finish yet stop counting as dead, and requeued tasks wait again for the parents of their workflow
+// which are not done.
+func (s *TaskStorage) requeue(ctx context.Context, condition string, args ...interface{}) (int, error) {
+	var count int
+
+	err := transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		type dead struct {
+			id         int64
+			taskID     string
+			action     string
+			groupID    string
+			workflowID string
+			parents    []string
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+		SELECT id, task_id, action, group_id, workflow_id, parents
+		FROM `+s.deadTable+`
+		WHERE `+condition+`
+		FOR UPDATE;
+	`, args...)
+
+		if err != nil {
+			return err
+		}
+
+		tasks := make([]dead, 0)
+		for rows.Next() {
+			t := dead{}
+			if err := rows.Scan(&t.id, &t.taskID, &t.action, &t.groupID, &t.workflowID, parentsScanner{&t.parents}); err != nil {
+				rows.Close()
+
+				return err
+			}
+
+			tasks = append(tasks, t)
+		}
+
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, t := range tasks {
+			pending, err := s.waiting(ctx, tx, t.workflowID, t.parents)
+			if err != nil {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, `
+		UPDATE `+s.stepTable+`
+		SET state = 'waiting'
+		WHERE workflow_id = ?
+			AND task_id = ?;
+	`, t.workflowID, t.taskID); err != nil {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, `
+		INSERT INTO `+s.todoTable+`(`+carried+`, last_error, pending)
+		SELECT `+carried+`, last_error, ?
+		FROM `+s.deadTable+`
+		WHERE id = ?;
+	`, pending, t.id); err != nil {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+s.deadTable+` WHERE id = ?;`, t.id); err != nil {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, `
+		INSERT INTO `+s.keyTable+`(action, task_id)
+		VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE expires_at = NULL;
+	`, t.action, t.taskID); err != nil {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, `
+		UPDATE `+s.groupTable+`
+		SET dead = dead - 1
+		WHERE group_id = ?
+			AND fired_at IS NULL;
+	`, t.groupID); err != nil {
+				return err
+			}
+		}
+
+		count = len(tasks)
+
+		return nil
+	})
+
+	return count, err
+}
+
+// Purge removes all tasks for action in the 'dead' state. An empty action matches all actions.
+func (s *TaskStorage) Purge(ctx context.Context, action string) (int, error) {
+	var count int64
+
+	err := transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `
+		DELETE s
+		FROM `+s.stepTable+` s
+			JOIN `+s.deadTable+` d ON s.workflow_id = d.workflow_id AND s.task_id = d.task_id
+		WHERE ? = '' OR d.action = ?;
+	`, action, action); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `
+		DELETE FROM `+s.deadTable+`
+		WHERE ? = '' OR action = ?;
+	`, action, action)
+
+		if err != nil {
+			return err
+		}
+
+		count, err = res.RowsAffected()
+
+		return err
+	})
+
+	if err != nil {
+		return 0, errors.Wrap(err, "error occurred purging dead tasks")
+	}
+
+	return int(count), nil
+}
+
+// ids runs a query selecting a single id column.
+func (s *TaskStorage) ids(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// matching returns the condition matching any of the action patterns, where a trailing '*' matches any suffix.
+func matching(actions []string) (string, []interface{}) {
+	escape := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+	conditions := make([]string, 0, len(actions))
+	args := make([]interface{}, 0, len(actions))
+	for _, action := range actions {
+		conditions = append(conditions, "action LIKE ?")
+
+		if strings.HasSuffix(action, "*") {
+			args = append(args, escape.Replace(strings.TrimSuffix(action, "*"))+"%")
+
+			continue
+		}
+
+		args = append(args, escape.Replace(action))
+	}
+
+	if len(conditions) == 0 {
+		return "FALSE", args
+	}
+
+	return "(" + strings.Join(conditions, " OR ") + ")", args
+}
+
+// list returns the placeholders and arguments for an IN list of ids.
+func list(ids []int64) (string, []interface{}) {
+	placeholders := make([]string, 0, len(ids))
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+
+	return strings.Join(placeholders, ", "), args
+}
+
+// delayUntil returns how long until t, or zero if t is not in the future.
+func delayUntil(t time.Time) time.Duration {
+	if t.IsZero() || t.Before(time.Now()) {
+		return 0
+	}
+
+	return time.Until(t)
+}
+
+func micros(d time.Duration) int64 {
+	return int64(d / time.Microsecond)
+}
+
+// durationScanner scans a duration stored in milliseconds.
+type durationScanner struct {
+	duration *time.Duration
+}
+
+// Scan implements sql.Scanner.
+func (d durationScanner) Scan(src interface{}) error {
+	var ms int64
+
+	switch src := src.(type) {
+	case int64:
+		ms = src
+	case []byte:
+		n, err := strconv.ParseInt(string(src), 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "cannot scan into a duration")
+		}
+
+		ms = n
+	default:
+		return errors.Errorf("cannot scan %T into a duration", src)
+	}
+
+	*d.duration = time.Duration(ms) * time.Millisecond
+
+	return nil
+}
+
+// newLeaseToken returns a random token identifying a claim.
+func newLeaseToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", errors.Wrap(err, "error occurred generating the lease token")
+	}
+
+	return hex.EncodeToString(token), nil
+}
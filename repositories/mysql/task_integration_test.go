@@ -0,0 +1,194 @@
+//go:build integration
+// +build integration
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	. "github.com/onsi/gomega"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+func testDSN() string {
+	if dsn := os.Getenv("MYSQL_DSN"); dsn != "" {
+		return dsn
+	}
+
+	return "root:root@tcp(localhost:3306)/tasks?parseTime=true"
+}
+
+func newTestStorage(t *testing.T) (*TaskStorage, func()) {
+	conn, err := sql.Open("mysql", testDSN())
+	Expect(err).ToNot(HaveOccurred())
+
+	storage := NewTaskStorage(conn, fmt.Sprintf("test_%d", time.Now().UnixNano()))
+	Expect(storage.Init(context.Background())).To(Succeed())
+
+	return &storage, func() {
+		for _, table := range []string{storage.todoTable, storage.doingTable, storage.doneTable, storage.deadTable, storage.groupTable, storage.keyTable, storage.stepTable} {
+			conn.Exec(`DROP TABLE IF EXISTS ` + table)
+		}
+
+		conn.Close()
+	}
+}
+
+func TestIdentifiersAreCaseAndAccentSensitive(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	for _, task := range []*taskworker.Task{
+		{TaskID: "1", Action: "export"},
+		{TaskID: "1", Action: "Export"},
+		{TaskID: "café", Action: "export"},
+		{TaskID: "cafe", Action: "export"},
+	} {
+		created, err := storage.Create(ctx, task)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(created).To(BeTrue(), "%s/%s should not be deduplicated by a case or accent insensitive collation", task.Action, task.TaskID)
+	}
+
+	batch, err := storage.GetBatch(ctx, []string{"export"}, 0, time.Minute, 10)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(batch).To(HaveLen(3), "an exact action should only claim its own tasks")
+
+	batch, err = storage.GetBatch(ctx, []string{"Exp*"}, 0, time.Minute, 10)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(batch).To(HaveLen(1), "a prefix should only claim the actions with that exact prefix")
+	Expect(batch[0].Action).To(Equal("Export"))
+
+	topics := NewTopics(storage.pool)
+	Expect(topics.Init(ctx)).To(Succeed())
+
+	topic := fmt.Sprintf("Test_%d", time.Now().UnixNano())
+	defer storage.pool.Exec(`DELETE FROM subscriptions WHERE topic = ?`, topic)
+
+	Expect(topics.Subscribe(ctx, topic, "email", "Email")).To(Succeed())
+	Expect(topics.Subscribers(ctx, topic)).To(Equal([]string{"email", "Email"}))
+}
+
+func TestTimesKeepTheirMicroseconds(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	_, err := storage.Create(ctx, &taskworker.Task{TaskID: "1", Action: "cmd", RunAt: time.Now().Add(300 * time.Millisecond)})
+	Expect(err).ToNot(HaveOccurred())
+
+	task, err := storage.Get(ctx, "cmd", 0, time.Minute)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(task).To(BeNil(), "a task should not be claimed before it is due")
+
+	Eventually(func() *taskworker.Task {
+		task, _ := storage.Get(ctx, "cmd", 0, 250*time.Millisecond)
+		return task
+	}, time.Second, 20*time.Millisecond).ShouldNot(BeNil(), "a task should be claimed once it is due")
+
+	batch, err := storage.GetBatch(ctx, []string{"cmd"}, 0, time.Minute, 1)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(batch).To(BeEmpty())
+
+	n, err := storage.Reclaim(ctx, []string{"cmd"})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(n).To(BeZero(), "a lease should not expire before its sub-second duration passes")
+
+	time.Sleep(300 * time.Millisecond)
+	n, err = storage.Reclaim(ctx, []string{"cmd"})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(n).To(Equal(1))
+
+	batch, err = storage.GetBatch(ctx, []string{"cmd"}, 0, 1500*time.Microsecond, 1)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(batch).To(HaveLen(1))
+
+	// the times are compared with each other, so the time zone of the server does not matter
+	lease := batch[0].LeaseExpiresAt.Sub(batch[0].StartedAt)
+	Expect(lease).To(BeNumerically("~", 1500*time.Microsecond, 100*time.Microsecond), "DATETIME(6) should keep the microseconds of the lease")
+	Expect(batch[0].CreatedAt.Nanosecond() % 1000).To(BeZero())
+}
+
+func TestResultsAreStoredAsJSON(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	results := map[string]interface{}{
+		"none":   nil,
+		"text":   "plain",
+		"number": 12345678901234567,
+		"nested": map[string]interface{}{"b": []int{1, 2}, "a": "ação"},
+	}
+
+	for id := range results {
+		_, err := storage.Create(ctx, &taskworker.Task{TaskID: id, Action: "export"})
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	for range results {
+		task, err := storage.Get(ctx, "export", 0, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+
+		task.Result = results[task.TaskID]
+		Expect(storage.Complete(ctx, task)).To(Succeed())
+	}
+
+	task, err := storage.Result(ctx, "export", "none")
+	Expect(err).ToNot(HaveOccurred())
+	Expect(task.Result).To(BeNil(), "a task completed without a result should store NULL")
+
+	for _, id := range []string{"text", "number", "nested"} {
+		expected, _ := json.Marshal(results[id])
+
+		task, err := storage.Result(ctx, "export", id)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(task.Result).To(BeAssignableToTypeOf(json.RawMessage{}))
+		Expect([]byte(task.Result.(json.RawMessage))).To(MatchJSON(expected), "the JSON column may reformat %s but should keep its value", id)
+	}
+}
+
+func TestClaimsSkipLockedRows(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	Expect(storage.CreateBatch(ctx, []*taskworker.Task{
+		{TaskID: "1", Action: "cmd"},
+		{TaskID: "2", Action: "cmd"},
+	})).To(Equal(2))
+
+	tx, err := storage.pool.BeginTx(ctx, nil)
+	Expect(err).ToNot(HaveOccurred())
+	defer tx.Rollback()
+
+	var locked string
+	Expect(tx.QueryRow(`SELECT task_id FROM ` + storage.todoTable + ` ORDER BY id LIMIT 1 FOR UPDATE`).Scan(&locked)).To(Succeed())
+
+	short, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	batch, err := storage.GetBatch(short, []string{"cmd"}, 0, time.Minute, 10)
+	Expect(err).ToNot(HaveOccurred(), "a claim should not wait for rows locked by another transaction")
+	Expect(batch).To(HaveLen(1))
+	Expect(batch[0].TaskID).ToNot(Equal(locked), "a row locked by another transaction should be skipped")
+}
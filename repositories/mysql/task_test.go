@@ -0,0 +1,20 @@
+package mysql
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestMatching(t *testing.T) {
+	RegisterTestingT(t)
+
+	condition, args := matching([]string{"orders.*", "user_created"})
+
+	Expect(condition).To(Equal("(action LIKE ? OR action LIKE ?)"))
+	Expect(args).To(Equal([]interface{}{"orders.%", `user\_created`}), "only a trailing '*' should be a wildcard")
+
+	condition, args = matching(nil)
+	Expect(condition).To(Equal("FALSE"))
+	Expect(args).To(BeEmpty())
+}
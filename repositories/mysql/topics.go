@@ -0,0 +1,110 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/psimoesSsimoes/go-task-fanout/repositories/transaction"
+)
+
+// Topics is a topic registry stored in the database, so the subscriptions made by any process are seen by every
+// dispatcher using it.
+type Topics struct {
+	pool *sql.DB
+}
+
+// NewTopics creates a topic registry.
+func NewTopics(conn *sql.DB) Topics {
+	return Topics{pool: conn}
+}
+
+// Init prepares the storage, if needed, to keep the subscriptions of the topics.
+func (t *Topics) Init(ctx context.Context) error {
+	_, err := t.pool.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS subscriptions
+		(
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			topic VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			command VARCHAR(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin NOT NULL,
+			subscribed_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			UNIQUE KEY subscription_idx (topic, command)
+		);
+	`)
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred creating the subscriptions table")
+	}
+
+	return nil
+}
+
+// Subscribe adds commands to the subscribers of topic. Commands which are already subscribed are ignored.
+func (t *Topics) Subscribe(ctx context.Context, topic string, commands ...string) error {
+	err := transaction.InTransaction(ctx, t.pool, func(ctx context.Context, tx *sql.Tx) error {
+		for _, command := range commands {
+			if _, err := tx.ExecContext(ctx, `
+		INSERT INTO subscriptions(topic, command)
+		VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE command = command;
+	`, topic, command); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred subscribing to the topic")
+	}
+
+	return nil
+}
+
+// Unsubscribe removes a command from the subscribers of topic.
+func (t *Topics) Unsubscribe(ctx context.Context, topic string, command string) error {
+	_, err := t.pool.ExecContext(ctx, `
+		DELETE FROM subscriptions
+		WHERE topic = ?
+			AND command = ?;
+	`, topic, command)
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred unsubscribing from the topic")
+	}
+
+	return nil
+}
+
+// Subscribers returns the commands subscribed to topic, in the order they subscribed.
+func (t *Topics) Subscribers(ctx context.Context, topic string) ([]string, error) {
+	rows, err := t.pool.QueryContext(ctx, `
+		SELECT command
+		FROM subscriptions
+		WHERE topic = ?
+		ORDER BY id;
+	`, topic)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred getting the topic subscribers")
+	}
+
+	defer rows.Close()
+
+	subscribers := make([]string, 0)
+	for rows.Next() {
+		var command string
+		if err := rows.Scan(&command); err != nil {
+			return nil, errors.Wrap(err, "error occurred getting the topic subscribers")
+		}
+
+		subscribers = append(subscribers, command)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred getting the topic subscribers")
+	}
+
+	return subscribers, nil
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// hold takes the dedup key of a task, unless another task holds it. A key is held while its task is in the 'todo' or
+// 'doing' state and until the dedup window passes after it is completed. Concurrent holders wait on the key's unique
+// constraint, so only one of them takes it.
+func (s *TaskStorage) hold(ctx context.Context, tx *sql.Tx, action string, taskID string) (bool, error) {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO workqueue.`+s.keyTable+` AS k (action, task_id)
+		VALUES ($1, $2)
+		ON CONFLICT (action, task_id) DO UPDATE SET expires_at = NULL
+		WHERE k.expires_at <= current_timestamp;
+	`, action, taskID)
+
+	if err != nil {
+		return false, errors.Wrap(err, "error occurred holding the dedup key")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, errors.Wrap(err, "error occurred holding the dedup key")
+	}
+
+	return n > 0, nil
+}
+
+// expire lets the dedup key of a completed task go once the dedup window passes.
+func (s *TaskStorage) expire(ctx context.Context, tx *sql.Tx, action string, taskID string) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE workqueue.`+s.keyTable+`
+		SET expires_at = current_timestamp + make_interval(secs => $3)
+		WHERE action = $1
+			AND task_id = $2;
+	`, action, taskID, s.window.Seconds())
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred expiring the dedup key")
+	}
+
+	return nil
+}
+
+// drop lets the dedup key of a dead task go, so the task can be added again.
+func (s *TaskStorage) drop(ctx context.Context, tx *sql.Tx, action string, taskID string) error {
+	_, err := tx.ExecContext(ctx, `
+		DELETE FROM workqueue.`+s.keyTable+`
+		WHERE action = $1
+			AND task_id = $2;
+	`, action, taskID)
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred dropping the dedup key")
+	}
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+// pending returns the SQL expression counting the parents, given as a TEXT[] expression, which are not done yet within
+// the workflow.
+func (s *TaskStorage) pending(workflowID string, parents string) string {
+	return `(
+			SELECT count(DISTINCT parent)
+			FROM unnest(` + parents + `) AS parent
+			WHERE NOT EXISTS (
+				SELECT 1
+				FROM workqueue.` + s.stepTable + ` s
+				WHERE s.workflow_id = ` + workflowID + `
+					AND s.task_id = parent
+					AND s.state = 'done'
+			)
+		)`
+}
+
+// lockParents locks the parents of a task within its workflow, so they are neither done nor dead until the task is
+// stored, and returns how many of them are not done yet. It fails if a parent is not part of the workflow or is dead.
+func (s *TaskStorage) lockParents(ctx context.Context, tx *sql.Tx, task *taskworker.Task) (int, error) {
+	if task.WorkflowID == "" {
+		if len(task.Parents) > 0 {
+			return 0, errors.Errorf("task %s has parents but is not part of a workflow", task.TaskID)
+		}
+
+		return 0, nil
+	}
+
+	if len(task.Parents) == 0 {
+		return 0, nil
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT task_id, state
+		FROM workqueue.`+s.stepTable+`
+		WHERE workflow_id = $1
+			AND task_id = ANY($2)
+		FOR SHARE;
+	`, task.WorkflowID, pq.Array(task.Parents))
+
+	if err != nil {
+		return 0, errors.Wrap(err, "error occurred locking the parent tasks")
+	}
+
+	states := make(map[string]string, len(task.Parents))
+	for rows.Next() {
+		var taskID, state string
+		if err := rows.Scan(&taskID, &state); err != nil {
+			rows.Close()
+
+			return 0, errors.Wrap(err, "error occurred locking the parent tasks")
+		}
+
+		states[taskID] = state
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, errors.Wrap(err, "error occurred locking the parent tasks")
+	}
+
+	pending := make(map[string]bool, len(task.Parents))
+	for _, parent := range task.Parents {
+		switch state, ok := states[parent]; {
+		case !ok:
+			return 0, errors.Errorf("parent %s of task %s is not part of workflow %s", parent, task.TaskID, task.WorkflowID)
+		case state == "dead":
+			return 0, errors.Errorf("parent %s of task %s is dead", parent, task.TaskID)
+		case state != "done":
+			pending[parent] = true
+		}
+	}
+
+	return len(pending), nil
+}
+
+// addStep adds a task to its workflow, if any. It fails if the workflow has a task with the same ID already.
+func (s *TaskStorage) addStep(ctx context.Context, tx *sql.Tx, task *taskworker.Task) error {
+	if task.WorkflowID == "" {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO workqueue.`+s.stepTable+`(workflow_id, task_id, action)
+		VALUES ($1, $2, $3);
+	`, task.WorkflowID, task.TaskID, task.Action)
+
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return errors.Errorf("task %s is already part of workflow %s", task.TaskID, task.WorkflowID)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred adding the task to its workflow")
+	}
+
+	return nil
+}
+
+// unblock marks a completed task as done within its workflow, counts it on the tasks waiting for it and notifies the
+// listeners of the tasks which became claimable.
+func (s *TaskStorage) unblock(ctx context.Context, tx *sql.Tx, workflowID string, taskID string) error {
+	if workflowID == "" {
+		return nil
+	}
+
+	// the step is marked on its own statement, so once a task being added meanwhile lets it go the children are
+	// updated with a snapshot which includes that task
+	res, err := tx.ExecContext(ctx, `
+		UPDATE workqueue.`+s.stepTable+`
+		SET state = 'done',
+			finished_at = current_timestamp
+		WHERE workflow_id = $1
+			AND task_id = $2
+			AND state <> 'done';
+	`, workflowID, taskID)
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred marking the task as done in its workflow")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "error occurred marking the task as done in its workflow")
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		WITH unblocked AS (
+			UPDATE workqueue.`+s.todoTable+`
+			SET pending = pending - 1
+			WHERE workflow_id = $1
+				AND parents @> ARRAY[$2]::TEXT[]
+				AND pending > 0
+			RETURNING action, pending
+		)
+		SELECT pg_notify($3, action)
+		FROM unblocked
+		WHERE pending = 0;
+	`, workflowID, taskID, notifyChannel(s.subject))
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred unblocking the child tasks")
+	}
+
+	return nil
+}
+
+// cascade marks a dead task as dead within its workflow and moves every task waiting, directly or not, for it to the
+// 'dead' state.
+func (s *TaskStorage) cascade(ctx context.Context, tx *sql.Tx, workflowID string, taskID string) error {
+	if workflowID == "" {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE workqueue.`+s.stepTable+`
+		SET state = 'dead'
+		WHERE workflow_id = $1
+			AND task_id = $2;
+	`, workflowID, taskID); err != nil {
+		return errors.Wrap(err, "error occurred marking the task as dead in its workflow")
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		WITH RECURSIVE descendants AS (
+			SELECT id, task_id
+			FROM workqueue.`+s.todoTable+`
+			WHERE workflow_id = $1
+				AND parents @> ARRAY[$2]::TEXT[]
+			UNION
+			SELECT t.id, t.task_id
+			FROM workqueue.`+s.todoTable+` t
+				JOIN descendants d ON t.workflow_id = $1 AND t.parents @> ARRAY[d.task_id]
+		), moved_rows AS (
+			DELETE FROM workqueue.`+s.todoTable+`
+			WHERE id IN (SELECT id FROM descendants)
+			RETURNING *
+		), dropped AS (
+			DELETE FROM workqueue.`+s.keyTable+` k
+			USING moved_rows m
+			WHERE k.action = m.action
+				AND k.task_id = m.task_id
+		), marked AS (
+			UPDATE workqueue.`+s.stepTable+` s
+			SET state = 'dead'
+			FROM moved_rows m
+			WHERE s.workflow_id = m.workflow_id
+				AND s.task_id = m.task_id
+		)
+		INSERT INTO workqueue.`+s.deadTable+`(`+carried+`, attempts, last_error)
+		SELECT `+carried+`, attempts, $3
+		FROM moved_rows
+		RETURNING group_id;
+	`, workflowID, taskID, fmt.Sprintf("parent task %s is dead", taskID))
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred burying the child tasks")
+	}
+
+	groups := make([]string, 0)
+	for rows.Next() {
+		var groupID string
+		if err := rows.Scan(&groupID); err != nil {
+			rows.Close()
+
+			return errors.Wrap(err, "error occurred burying the child tasks")
+		}
+
+		groups = append(groups, groupID)
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "error occurred burying the child tasks")
+	}
+
+	for _, groupID := range groups {
+		if err := s.settle(ctx, tx, groupID, 0, 1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/psimoesSsimoes/go-task-fanout/repositories/transaction"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+// CreateGroup stores the tasks of a group for processing, either all of them or none. Completing or burying the last
+// member adds the group callback.
+func (s *TaskStorage) CreateGroup(ctx context.Context, group *taskworker.Group, tasks []*taskworker.Task) error {
+	data, err := json.Marshal(group.Callback.Data)
+	if err != nil {
+		return errors.Wrap(err, "error occurred creating the group")
+	}
+
+	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		total := 0
+		for _, task := range tasks {
+			task.GroupID = group.ID
+
+			inserted, err := s.insert(ctx, tx, task)
+			if err != nil {
+				return err
+			}
+
+			if inserted {
+				total++
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `
+		INSERT INTO workqueue.`+s.groupTable+`(group_id, total, callback_task_id, callback_action, callback_data, callback_priority, callback_on_dead)
+		VALUES ($1, $2, $3, $4, $5, $6, $7);
+	`, group.ID, total, group.Callback.TaskID, group.Callback.Action, data, group.Callback.Priority, group.CallbackOnDead)
+
+		if err != nil {
+			if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+				return errors.New("group already exists")
+			}
+
+			return errors.Wrap(err, "error occurred creating the group")
+		}
+
+		// a group whose members were all deduplicated is finished already
+		return s.settle(ctx, tx, group.ID, 0, 0)
+	})
+}
+
+// settle counts a finished member of a group and adds the group callback once every member is finished.
+func (s *TaskStorage) settle(ctx context.Context, tx *sql.Tx, groupID string, completed int, dead int) error {
+	if groupID == "" {
+		return nil
+	}
+
+	summary := taskworker.GroupSummary{GroupID: groupID}
+	callback := &taskworker.Task{}
+	var data []byte
+	var onDead, fired bool
+
+	err := tx.QueryRowContext(ctx, `
+		UPDATE workqueue.`+s.groupTable+`
+		SET completed = completed + $2,
+			dead = dead + $3
+		WHERE group_id = $1
+		RETURNING total, completed, dead, callback_task_id, callback_action, callback_data, callback_priority,
+			callback_on_dead, fired_at IS NOT NULL;
+	`, groupID, completed, dead).Scan(
+		&summary.Total,
+		&summary.Completed,
+		&summary.Dead,
+		&callback.TaskID,
+		&callback.Action,
+		&data,
+		&callback.Priority,
+		&onDead,
+		&fired,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+
+		return errors.Wrap(err, "error occurred settling the group")
+	}
+
+	summary.Data = data
+
+	if fired || summary.Completed+summary.Dead < summary.Total || (summary.Dead > 0 && !onDead) {
+		return nil
+	}
+
+	if summary.Dead > 0 {
+		rows, err := tx.QueryContext(ctx, `
+		SELECT task_id
+		FROM workqueue.`+s.deadTable+`
+		WHERE group_id = $1;
+	`, groupID)
+
+		if err != nil {
+			return errors.Wrap(err, "error occurred settling the group")
+		}
+
+		defer rows.Close()
+
+		for rows.Next() {
+			var taskID string
+			if err := rows.Scan(&taskID); err != nil {
+				return errors.Wrap(err, "error occurred settling the group")
+			}
+
+			summary.DeadTaskIDs = append(summary.DeadTaskIDs, taskID)
+		}
+
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "error occurred settling the group")
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE workqueue.`+s.groupTable+`
+		SET fired_at = current_timestamp
+		WHERE group_id = $1;
+	`, groupID); err != nil {
+		return errors.Wrap(err, "error occurred settling the group")
+	}
+
+	callback.Data = summary
+	if _, err := s.insert(ctx, tx, callback); err != nil {
+		return errors.Wrap(err, "error occurred adding the group callback")
+	}
+
+	return nil
+}
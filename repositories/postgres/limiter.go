@@ -0,0 +1,107 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RateLimiter is a token bucket stored in the database, so the same rate limit is shared by every receiver using it,
+// whichever replica they run on.
+type RateLimiter struct {
+	pool  *sql.DB
+	name  string
+	rate  float64
+	burst int
+}
+
+// NewRateLimiter creates a rate limiter allowing perSecond tasks on average and up to burst tasks at once. Limiters
+// with the same name share their tokens. A burst lower than 1 is raised to 1, while a rate which is not positive is
+// rejected.
+func NewRateLimiter(conn *sql.DB, name string, perSecond float64, burst int) (RateLimiter, error) {
+	if perSecond <= 0 {
+		return RateLimiter{}, errors.Errorf("rate limit must be positive, got %v", perSecond)
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return RateLimiter{
+		pool:  conn,
+		name:  name,
+		rate:  perSecond,
+		burst: burst,
+	}, nil
+}
+
+// Init prepares the storage, if needed, to keep the tokens of the rate limiter.
+func (l *RateLimiter) Init(ctx context.Context) error {
+	_, err := l.pool.ExecContext(ctx, `
+		CREATE SCHEMA IF NOT EXISTS workqueue;
+
+		CREATE TABLE IF NOT EXISTS workqueue.rate_limits
+		(
+			name TEXT PRIMARY KEY,
+			tokens DOUBLE PRECISION NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT clock_timestamp()
+		);
+	`)
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred creating the rate limit table")
+	}
+
+	if _, err := l.pool.ExecContext(ctx, `
+		INSERT INTO workqueue.rate_limits(name, tokens)
+		VALUES ($1, $2)
+		ON CONFLICT (name) DO NOTHING;
+	`, l.name, l.burst); err != nil {
+		return errors.Wrap(err, "error occurred creating the rate limit")
+	}
+
+	return nil
+}
+
+// Wait blocks until a token is available and takes it. It returns an error if ctx is done first.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		var tokens float64
+
+		// the bucket is refilled for the time passed since it was last updated, and a token is only taken if there is one
+		err := l.pool.QueryRowContext(ctx, `
+		WITH refilled AS (
+			SELECT LEAST($3, tokens + EXTRACT(EPOCH FROM clock_timestamp() - updated_at) * $2) AS tokens
+			FROM workqueue.rate_limits
+			WHERE name = $1
+			FOR UPDATE
+		)
+		UPDATE workqueue.rate_limits
+		SET tokens = CASE WHEN refilled.tokens >= 1 THEN refilled.tokens - 1 ELSE refilled.tokens END,
+			updated_at = clock_timestamp()
+		FROM refilled
+		WHERE name = $1
+		RETURNING refilled.tokens;
+	`, l.name, l.rate, float64(l.burst)).Scan(&tokens)
+
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return errors.New("rate limit does not exist")
+			}
+
+			return errors.Wrap(err, "error occurred taking a rate limit token")
+		}
+
+		if tokens >= 1 {
+			return nil
+		}
+
+		select {
+		case <-time.After(time.Duration((1 - tokens) / l.rate * float64(time.Second))):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
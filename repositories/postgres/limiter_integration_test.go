@@ -0,0 +1,49 @@
+//go:build integration
+// +build integration
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRateLimiterIsShared(t *testing.T) {
+	RegisterTestingT(t)
+
+	conn, err := sql.Open("postgres", testDSN())
+	Expect(err).ToNot(HaveOccurred())
+	defer conn.Close()
+
+	ctx := context.Background()
+	name := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	defer conn.Exec(`DELETE FROM workqueue.rate_limits WHERE name = $1`, name)
+
+	first, err := NewRateLimiter(conn, name, 10, 2)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(first.Init(ctx)).To(Succeed())
+
+	second, err := NewRateLimiter(conn, name, 10, 2)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(second.Init(ctx)).To(Succeed())
+
+	start := time.Now()
+	Expect(first.Wait(ctx)).To(Succeed())
+	Expect(second.Wait(ctx)).To(Succeed())
+	Expect(time.Since(start)).To(BeNumerically("<", 50*time.Millisecond), "a full bucket should allow a burst")
+
+	Expect(second.Wait(ctx)).To(Succeed())
+	Expect(time.Since(start)).To(BeNumerically(">=", 90*time.Millisecond), "limiters with the same name should share their tokens")
+
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	Expect(first.Wait(short)).ToNot(Succeed(), "waiting should stop once the context is done")
+
+	_, err = NewRateLimiter(conn, name, 0, 1)
+	Expect(err).To(HaveOccurred(), "a rate which is not positive should be rejected")
+}
@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+// Notifier listens for the notifications sent by TaskStorage whenever tasks become claimable and wakes up the subscribed
+// receivers.
+type Notifier struct {
+	mux         *sync.Mutex
+	listener    *pq.Listener
+	subscribers map[chan bool][]string
+	done        chan bool
+	closing     *sync.Once
+	closeErr    error
+}
+
+// NewNotifier creates a notifier for the tasks of a subject. It needs its own connection, so it takes a connection string.
+func NewNotifier(dsn string, subject string) (*Notifier, error) {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, nil)
+	if err := listener.Listen(notifyChannel(subject)); err != nil {
+		listener.Close()
+
+		return nil, errors.Wrap(err, "error occurred listening for tasks")
+	}
+
+	n := &Notifier{
+		mux:         &sync.Mutex{},
+		listener:    listener,
+		subscribers: make(map[chan bool][]string),
+		done:        make(chan bool),
+		closing:     &sync.Once{},
+	}
+
+	go n.run()
+
+	return n, nil
+}
+
+// Subscribe returns a channel which receives a signal whenever a task matching any of the action patterns becomes
+// claimable. Signals are coalesced while nobody reads them. Call the returned function to unsubscribe.
+func (n *Notifier) Subscribe(actions ...string) (<-chan bool, func()) {
+	wake := make(chan bool, 1)
+
+	n.mux.Lock()
+	n.subscribers[wake] = actions
+	n.mux.Unlock()
+
+	return wake, func() {
+		n.mux.Lock()
+		defer n.mux.Unlock()
+
+		delete(n.subscribers, wake)
+	}
+}
+
+// Close stops listening for notifications. Closing the notifier again does nothing.
+func (n *Notifier) Close() error {
+	n.closing.Do(func() {
+		close(n.done)
+		n.closeErr = n.listener.Close()
+	})
+
+	return n.closeErr
+}
+
+func (n *Notifier) run() {
+	for {
+		select {
+		case notification := <-n.listener.Notify:
+			// a nil notification means the connection was re-established and notifications may have been lost
+			if notification == nil {
+				n.wakeAll()
+
+				continue
+			}
+
+			n.wake(notification.Extra)
+		case <-time.After(90 * time.Second):
+			go n.listener.Ping()
+		case <-n.done:
+			return
+		}
+	}
+}
+
+func (n *Notifier) wake(action string) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	for subscriber, patterns := range n.subscribers {
+		for _, pattern := range patterns {
+			if taskworker.MatchAction(pattern, action) {
+				signal(subscriber)
+
+				break
+			}
+		}
+	}
+}
+
+func (n *Notifier) wakeAll() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	for subscriber := range n.subscribers {
+		signal(subscriber)
+	}
+}
+
+func signal(wake chan bool) {
+	select {
+	case wake <- true:
+	default:
+	}
+}
+
+func notifyChannel(subject string) string {
+	return "workqueue_" + subject
+}
+
+// notify wakes up the receivers of the given actions once the transaction commits.
+func (s *TaskStorage) notify(ctx context.Context, tx *sql.Tx, actions ...string) error {
+	if len(actions) == 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		SELECT pg_notify($1, action)
+		FROM unnest($2::TEXT[]) AS action;
+	`, notifyChannel(s.subject), pq.Array(actions)); err != nil {
+		return errors.Wrap(err, "error occurred notifying the tasks")
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+//go:build integration
+// +build integration
+
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+func TestNotifierWakesOnClaimableTasks(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	notifier, err := NewNotifier(testDSN(), storage.subject)
+	Expect(err).ToNot(HaveOccurred())
+
+	wake, unsubscribe := notifier.Subscribe("orders.*")
+	defer unsubscribe()
+
+	ctx := context.Background()
+	woken := func() bool {
+		select {
+		case <-wake:
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+
+	_, err = storage.Create(ctx, &taskworker.Task{TaskID: "1", Action: "orders.created"})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(woken()).To(BeTrue(), "creating a task should wake its receivers")
+
+	_, err = storage.Create(ctx, &taskworker.Task{TaskID: "1", Action: "users.created"})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(woken()).To(BeFalse(), "other actions should not wake the receivers")
+
+	task, _ := storage.Get(ctx, "orders.created", 0, time.Minute)
+	Expect(storage.Fail(ctx, task, "boom", 0)).To(Succeed())
+	Expect(woken()).To(BeTrue(), "a task retried right away should wake its receivers")
+
+	task, _ = storage.Get(ctx, "orders.created", 0, time.Minute)
+	Expect(storage.Snooze(ctx, task, time.Now().Add(time.Hour))).To(Succeed())
+	Expect(woken()).To(BeFalse(), "a task snoozed for later should not wake the receivers")
+
+	_, err = storage.Create(ctx, &taskworker.Task{TaskID: "2", Action: "orders.created"})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(woken()).To(BeTrue())
+
+	tasks, err := storage.GetBatch(ctx, []string{"orders.*"}, 0, time.Minute, 10)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(tasks).To(HaveLen(1))
+	Expect(storage.Release(ctx, tasks)).To(Succeed())
+	Expect(woken()).To(BeTrue(), "releasing a task should wake its receivers")
+
+	_, err = storage.Get(ctx, "orders.created", 0, time.Millisecond)
+	Expect(err).ToNot(HaveOccurred())
+	time.Sleep(10 * time.Millisecond)
+	reclaimed, err := storage.Reclaim(ctx, []string{"orders.*"})
+	Expect(err).ToNot(HaveOccurred())
+	Expect(reclaimed).To(Equal(1))
+	Expect(woken()).To(BeTrue(), "reclaiming a task should wake its receivers")
+
+	Expect(notifier.Close()).To(Succeed())
+	Expect(notifier.Close()).To(Succeed(), "closing the notifier again should do nothing")
+}
@@ -26,9 +26,9 @@ func (r *RegisterRepository) GetSeveralTask(ctx context.Context, action string,
 	return []models.Task{{}}, nil
 }
 func (r *RegisterRepository) MarkAsDone(ctx context.Context, action string, age time.Duration) error {
-	return models.Task{}, nil
+	return nil
 }
 
 func (r *RegisterRepository) MarkSeveralAsDone(ctx context.Context, action string, age time.Duration) error {
-	return models.Task{}, nil
+	return nil
 }
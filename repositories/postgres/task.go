@@ -2,42 +2,77 @@ package postgres
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"time"
 
 	"fmt"
+	"strings"
 
 	"encoding/json"
 
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	"github.com/psimoesSsimoes/go-task-fanout/repositories/transaction"
 	taskworker "gitlab.com/marcoxavier/go-taskworker"
 )
 
+// carried are the columns a task keeps while it moves between the todo, doing, done and dead tables.
+const carried = "id, task_id, action, data, created_at, priority, group_id, workflow_id, parents, timeout_ms"
+
 // TaskStorage manages tasks.
 type TaskStorage struct {
 	pool       *sql.DB
 	subject    string
 	todoTable  string
 	doingTable string
+	doneTable  string
+	deadTable  string
+	groupTable string
+	keyTable   string
+	stepTable  string
+	window     time.Duration
+}
+
+// StorageOption is the abstract functional-parameter type used for storage configuration.
+type StorageOption func(*TaskStorage)
+
+// WithDedupWindow allows you to keep deduplicating a task for the given duration after it is completed. By default a
+// task is only deduplicated while it is in the 'todo' or 'doing' state.
+func WithDedupWindow(window time.Duration) StorageOption {
+	return func(s *TaskStorage) {
+		if window >= 0 {
+			s.window = window
+		}
+	}
 }
 
 // NewTaskStorage creates a task storage
-func NewTaskStorage(conn *sql.DB, subject string) TaskStorage {
-	return TaskStorage{
-		conn:       conn,
+func NewTaskStorage(conn *sql.DB, subject string, opts ...StorageOption) TaskStorage {
+	s := TaskStorage{
+		pool:       conn,
 		subject:    subject,
 		todoTable:  fmt.Sprintf("%s_todo", subject),
 		doingTable: fmt.Sprintf("%s_doing", subject),
+		doneTable:  fmt.Sprintf("%s_done", subject),
+		deadTable:  fmt.Sprintf("%s_dead", subject),
+		groupTable: fmt.Sprintf("%s_groups", subject),
+		keyTable:   fmt.Sprintf("%s_keys", subject),
+		stepTable:  fmt.Sprintf("%s_steps", subject),
 	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return s
 }
 
 // Init prepares the storage, if needed, to manage task to a specific subject.
 func (s *TaskStorage) Init(ctx context.Context) error {
 	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
-		_, err := s.pool.ExecContext(ctx, `
-		BEGIN;
-
+		_, err := tx.ExecContext(ctx, `
 		CREATE schema IF NOT EXISTS workqueue;
 
 		CREATE TABLE IF NOT EXISTS workqueue.`+s.todoTable+`
@@ -46,7 +81,17 @@ func (s *TaskStorage) Init(ctx context.Context) error {
 			task_id TEXT NOT NULL,
 			action TEXT NOT NULL,
 			data JSONB DEFAULT '{}',
-			created_at TIMESTAMP DEFAULT NOW()
+			created_at TIMESTAMP DEFAULT NOW(),
+			priority INT NOT NULL DEFAULT 0,
+			group_id TEXT NOT NULL DEFAULT '',
+			workflow_id TEXT NOT NULL DEFAULT '',
+			parents TEXT[] NOT NULL DEFAULT '{}',
+			timeout_ms BIGINT NOT NULL DEFAULT 0,
+			pending INT NOT NULL DEFAULT 0,
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			next_run_at TIMESTAMP,
+			run_at TIMESTAMP NOT NULL DEFAULT NOW()
 		);
 
 		CREATE TABLE IF NOT EXISTS workqueue.`+s.doingTable+`
@@ -56,10 +101,147 @@ func (s *TaskStorage) Init(ctx context.Context) error {
 			action TEXT NOT NULL,
 			data JSONB DEFAULT '{}',
 			created_at TIMESTAMP DEFAULT NOW(),
-			started_at TIMESTAMP DEFAULT NOW()
+			priority INT NOT NULL DEFAULT 0,
+			group_id TEXT NOT NULL DEFAULT '',
+			workflow_id TEXT NOT NULL DEFAULT '',
+			parents TEXT[] NOT NULL DEFAULT '{}',
+			timeout_ms BIGINT NOT NULL DEFAULT 0,
+			started_at TIMESTAMP DEFAULT NOW(),
+			lease_expires_at TIMESTAMP,
+			lease_token TEXT,
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT
 		);
 
-		COMMIT;
+		CREATE TABLE IF NOT EXISTS workqueue.`+s.doneTable+`
+		(
+			id SERIAL PRIMARY KEY,
+			task_id TEXT NOT NULL,
+			action TEXT NOT NULL,
+			data JSONB DEFAULT '{}',
+			created_at TIMESTAMP DEFAULT NOW(),
+			priority INT NOT NULL DEFAULT 0,
+			group_id TEXT NOT NULL DEFAULT '',
+			workflow_id TEXT NOT NULL DEFAULT '',
+			parents TEXT[] NOT NULL DEFAULT '{}',
+			timeout_ms BIGINT NOT NULL DEFAULT 0,
+			started_at TIMESTAMP,
+			attempts INT NOT NULL DEFAULT 0,
+			finished_at TIMESTAMP DEFAULT NOW(),
+			result JSONB
+		);
+
+		CREATE INDEX IF NOT EXISTS `+s.doneTable+`_finished_idx
+			ON workqueue.`+s.doneTable+`(action, finished_at);
+
+		CREATE INDEX IF NOT EXISTS `+s.doneTable+`_result_idx
+			ON workqueue.`+s.doneTable+`(action, task_id, finished_at);
+
+		CREATE TABLE IF NOT EXISTS workqueue.`+s.deadTable+`
+		(
+			id SERIAL PRIMARY KEY,
+			task_id TEXT NOT NULL,
+			action TEXT NOT NULL,
+			data JSONB DEFAULT '{}',
+			created_at TIMESTAMP DEFAULT NOW(),
+			priority INT NOT NULL DEFAULT 0,
+			group_id TEXT NOT NULL DEFAULT '',
+			workflow_id TEXT NOT NULL DEFAULT '',
+			parents TEXT[] NOT NULL DEFAULT '{}',
+			timeout_ms BIGINT NOT NULL DEFAULT 0,
+			attempts INT NOT NULL DEFAULT 0,
+			last_error TEXT,
+			dead_at TIMESTAMP DEFAULT NOW()
+		);
+
+		CREATE TABLE IF NOT EXISTS workqueue.`+s.groupTable+`
+		(
+			group_id TEXT PRIMARY KEY,
+			total INT NOT NULL,
+			completed INT NOT NULL DEFAULT 0,
+			dead INT NOT NULL DEFAULT 0,
+			callback_task_id TEXT NOT NULL,
+			callback_action TEXT NOT NULL,
+			callback_data JSONB DEFAULT '{}',
+			callback_priority INT NOT NULL DEFAULT 0,
+			callback_on_dead BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP DEFAULT NOW(),
+			fired_at TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS workqueue.`+s.keyTable+`
+		(
+			action TEXT NOT NULL,
+			task_id TEXT NOT NULL,
+			expires_at TIMESTAMP,
+			PRIMARY KEY (action, task_id)
+		);
+
+		CREATE TABLE IF NOT EXISTS workqueue.`+s.stepTable+`
+		(
+			workflow_id TEXT NOT NULL,
+			task_id TEXT NOT NULL,
+			action TEXT NOT NULL,
+			state TEXT NOT NULL DEFAULT 'waiting',
+			finished_at TIMESTAMP,
+			PRIMARY KEY (workflow_id, task_id)
+		);
+
+		ALTER TABLE workqueue.`+s.todoTable+`
+			ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS last_error TEXT,
+			ADD COLUMN IF NOT EXISTS next_run_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS run_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			ADD COLUMN IF NOT EXISTS priority INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS group_id TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS workflow_id TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS parents TEXT[] NOT NULL DEFAULT '{}',
+			ADD COLUMN IF NOT EXISTS pending INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS timeout_ms BIGINT NOT NULL DEFAULT 0;
+
+		ALTER TABLE workqueue.`+s.doingTable+`
+			ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP,
+			ADD COLUMN IF NOT EXISTS lease_token TEXT,
+			ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS last_error TEXT,
+			ADD COLUMN IF NOT EXISTS priority INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS group_id TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS workflow_id TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS parents TEXT[] NOT NULL DEFAULT '{}',
+			ADD COLUMN IF NOT EXISTS timeout_ms BIGINT NOT NULL DEFAULT 0;
+
+		ALTER TABLE workqueue.`+s.doneTable+`
+			ADD COLUMN IF NOT EXISTS priority INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS group_id TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS workflow_id TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS parents TEXT[] NOT NULL DEFAULT '{}',
+			ADD COLUMN IF NOT EXISTS timeout_ms BIGINT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS result JSONB;
+
+		ALTER TABLE workqueue.`+s.deadTable+`
+			ADD COLUMN IF NOT EXISTS priority INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS group_id TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS workflow_id TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS parents TEXT[] NOT NULL DEFAULT '{}',
+			ADD COLUMN IF NOT EXISTS timeout_ms BIGINT NOT NULL DEFAULT 0;
+
+		CREATE INDEX IF NOT EXISTS `+s.todoTable+`_claim_idx
+			ON workqueue.`+s.todoTable+`(action, priority DESC, created_at ASC);
+
+		CREATE INDEX IF NOT EXISTS `+s.todoTable+`_parents_idx
+			ON workqueue.`+s.todoTable+` USING GIN (parents);
+
+		CREATE INDEX IF NOT EXISTS `+s.doingTable+`_lease_idx
+			ON workqueue.`+s.doingTable+`(action, lease_expires_at);
+
+		CREATE INDEX IF NOT EXISTS `+s.deadTable+`_group_idx
+			ON workqueue.`+s.deadTable+`(group_id);
+
+		INSERT INTO workqueue.`+s.keyTable+`(action, task_id)
+		SELECT action, task_id FROM workqueue.`+s.todoTable+`
+		UNION
+		SELECT action, task_id FROM workqueue.`+s.doingTable+`
+		ON CONFLICT DO NOTHING;
 	`)
 
 		if err != nil {
@@ -72,97 +254,612 @@ func (s *TaskStorage) Init(ctx context.Context) error {
 
 }
 
-// Create stores a task for processing.
-func (s *TaskStorage) Create(ctx context.Context, task *taskworker.Task) error {
+// Create stores a task for processing. It returns false if the task was deduplicated.
+func (s *TaskStorage) Create(ctx context.Context, task *taskworker.Task) (bool, error) {
+	var inserted bool
+
+	err := transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		inserted, err = s.insert(ctx, tx, task)
+
+		return err
+	})
+
+	return inserted, err
+}
+
+// CreateBatch stores several tasks for processing, either all of them or none, and returns how many were stored. Tasks
+// which are deduplicated are skipped rather than failing the batch.
+func (s *TaskStorage) CreateBatch(ctx context.Context, tasks []*taskworker.Task) (int, error) {
+	var count int
+
+	err := transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		for _, task := range tasks {
+			inserted, err := s.insert(ctx, tx, task)
+			if err != nil {
+				return err
+			}
+
+			if inserted {
+				count++
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// insert stores a task in the 'todo' state and notifies its listeners, unless a task with the same action and ID holds
+// its dedup key. It returns whether the task was stored.
+func (s *TaskStorage) insert(ctx context.Context, tx *sql.Tx, task *taskworker.Task) (bool, error) {
 	data, err := json.Marshal(task.Data)
 	if err != nil {
-		return errors.Wrap(err, "error occurred creating the task")
+		return false, errors.Wrap(err, "error occurred creating the task")
+	}
+
+	held, err := s.hold(ctx, tx, task.Action, task.TaskID)
+	if err != nil {
+		return false, err
+	}
+
+	if !held {
+		if task.WorkflowID != "" {
+			return false, errors.Errorf("task %s of workflow %s is already waiting or running", task.TaskID, task.WorkflowID)
+		}
+
+		return false, nil
+	}
+
+	pending, err := s.lockParents(ctx, tx, task)
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.addStep(ctx, tx, task); err != nil {
+		return false, err
+	}
+
+	parents := task.Parents
+	if parents == nil {
+		parents = []string{}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO workqueue.`+s.todoTable+`(task_id, action, data, run_at, priority, group_id, workflow_id, parents, pending, timeout_ms)
+		VALUES ($1, $2, $3, current_timestamp + make_interval(secs => $4), $5, $6, $7, $8, $9, $10);
+	`, task.TaskID, task.Action, data, delayUntil(task.RunAt).Seconds(), task.Priority, task.GroupID, task.WorkflowID, pq.Array(parents), pending, millis(task.Timeout))
+
+	if err != nil {
+		return false, errors.Wrap(err, "error occurred creating the task")
+	}
+
+	if err := s.notify(ctx, tx, task.Action); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Get returns the next Task for command which is in the 'todo' state, leasing it for the given duration.
+func (s *TaskStorage) Get(ctx context.Context, action string, age time.Duration, lease time.Duration) (*taskworker.Task, error) {
+	tasks, err := s.GetBatch(ctx, []string{action}, age, lease, 1)
+	if err != nil {
+		return nil, err
+	}
 
+	if len(tasks) == 0 {
+		return nil, nil
+	}
+
+	return tasks[0], nil
+}
+
+// GetBatch returns the next N Tasks matching any of the action patterns which are in the 'todo' state, leasing them
+// for the given duration. Rows locked by a concurrent claim are skipped, so concurrent receivers never block each
+// other nor claim the same task. The tasks share a new lease token, which every later transition must present.
+func (s *TaskStorage) GetBatch(ctx context.Context, actions []string, age time.Duration, lease time.Duration, n int) ([]*taskworker.Task, error) {
+	token, err := newLeaseToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// the rows returned by a data-modifying statement come in no particular order, so the claimed tasks are sorted again
+	rows, err := s.pool.QueryContext(ctx, `
+		WITH moved_rows AS (
+			DELETE FROM workqueue.`+s.todoTable+`
+			WHERE id IN (
+				SELECT id
+				FROM workqueue.`+s.todoTable+`
+				WHERE action LIKE ANY($1)
+					AND created_at <= current_timestamp - make_interval(secs => $2)
+					AND run_at <= current_timestamp
+					AND (next_run_at IS NULL OR next_run_at <= current_timestamp)
+					AND pending = 0
+				ORDER BY priority DESC, created_at ASC
+				LIMIT $4
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING *
+		), claimed AS (
+			INSERT INTO workqueue.`+s.doingTable+`(`+carried+`, lease_expires_at, lease_token, attempts, last_error)
+			SELECT `+carried+`, current_timestamp + make_interval(secs => $3), $5, attempts, last_error
+			FROM moved_rows
+			RETURNING `+carried+`, started_at, lease_expires_at, lease_token, attempts, last_error
+		)
+		SELECT `+carried+`, started_at, lease_expires_at, lease_token, attempts, COALESCE(last_error, '')
+		FROM claimed
+		ORDER BY priority DESC, created_at ASC, id ASC;
+	`, pq.Array(likePatterns(actions)), age.Seconds(), lease.Seconds(), n, token)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred getting tasks")
+	}
+
+	defer rows.Close()
+
+	tasks := make([]*taskworker.Task, 0)
+
+	for rows.Next() {
+		if err = rows.Err(); err != nil {
+			return tasks, errors.Wrap(err, "rows.Next row has error")
+		}
+
+		task := &taskworker.Task{}
+
+		if err := rows.Scan(
+			&task.ID,
+			&task.TaskID,
+			&task.Action,
+			&task.Data,
+			&task.CreatedAt,
+			&task.Priority,
+			&task.GroupID,
+			&task.WorkflowID,
+			pq.Array(&task.Parents),
+			durationScanner{&task.Timeout},
+			&task.StartedAt,
+			&task.LeaseExpiresAt,
+			&task.LeaseToken,
+			&task.Attempts,
+			&task.LastError,
+		); err != nil {
+			return nil, errors.Wrap(err, "error occurred getting tasks")
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
+// Extend pushes the lease of a task in the 'doing' state to the given duration from now.
+func (s *TaskStorage) Extend(ctx context.Context, task *taskworker.Task, lease time.Duration) error {
+	row := s.pool.QueryRowContext(ctx, `
+		UPDATE workqueue.`+s.doingTable+`
+		SET lease_expires_at = current_timestamp + make_interval(secs => $2)
+		WHERE id = $1
+			AND lease_token = $3
+		RETURNING lease_expires_at;
+	`, task.ID, lease.Seconds(), task.LeaseToken)
+
+	if err := row.Scan(&task.LeaseExpiresAt); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.New("task is no longer leased")
+		}
+
+		return errors.Wrap(err, "error occurred extending the task lease")
+	}
+
+	return nil
+}
+
+// Reclaim moves all tasks matching any of the action patterns in the 'doing' state whose lease has expired back to the
+// 'todo' state, counting a failed attempt with LeaseExpired as its error.
+func (s *TaskStorage) Reclaim(ctx context.Context, actions []string) (int, error) {
+	var count int64
+
+	err := transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		var reclaimed []string
+
+		err := tx.QueryRowContext(ctx, `
+		WITH moved_rows AS (
+			DELETE FROM workqueue.`+s.doingTable+`
+			WHERE id IN (
+				SELECT id
+				FROM workqueue.`+s.doingTable+`
+				WHERE action LIKE ANY($1)
+					AND lease_expires_at < current_timestamp
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING *
+		), moved AS (
+			INSERT INTO workqueue.`+s.todoTable+`(`+carried+`, attempts, last_error)
+			SELECT `+carried+`, attempts + 1, $2
+			FROM moved_rows
+			RETURNING action
+		)
+		SELECT count(*), array_agg(DISTINCT action)
+		FROM moved;
+	`, pq.Array(likePatterns(actions)), taskworker.LeaseExpired).Scan(&count, pq.Array(&reclaimed))
+
+		if err != nil {
+			return errors.Wrap(err, "error occurred reclaiming tasks")
+		}
+
+		return s.notify(ctx, tx, reclaimed...)
+	})
+
+	return int(count), err
+}
+
+// Release moves tasks which were claimed but never handled back to the 'todo' state.
+func (s *TaskStorage) Release(ctx context.Context, tasks []*taskworker.Task) error {
+	ids := make([]int64, 0, len(tasks))
+	tokens := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		ids = append(ids, int64(task.ID))
+		tokens = append(tokens, task.LeaseToken)
 	}
 
 	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		var released []string
 
-		_, err := s.conn.ExecContext(ctx, `
-		INSERT INTO workqueue.`+s.todoTable+`(task_id, action, data)
-		SELECT $1, $2, $3
-		FROM   workqueue.`+s.todoTable+`
-		WHERE  task_id = $1
-			AND action = $2
-		HAVING count(1) = 0;
-	`, task.TaskID, task.Action, data)
+		err := tx.QueryRowContext(ctx, `
+		WITH moved_rows AS (
+			DELETE FROM workqueue.`+s.doingTable+`
+			WHERE (id, lease_token) IN (SELECT * FROM unnest($1::BIGINT[], $2::TEXT[]))
+			RETURNING *
+		), moved AS (
+			INSERT INTO workqueue.`+s.todoTable+`(`+carried+`, attempts, last_error)
+			SELECT `+carried+`, attempts, last_error
+			FROM moved_rows
+			RETURNING action
+		)
+		SELECT array_agg(DISTINCT action)
+		FROM moved;
+	`, pq.Array(ids), pq.Array(tokens)).Scan(pq.Array(&released))
 
 		if err != nil {
-			return errors.Wrap(err, "error occurred creating the task")
+			return errors.Wrap(err, "error occurred releasing the tasks")
 		}
 
-		return err
+		return s.notify(ctx, tx, released...)
 	})
 }
 
-// Get returns the next Task for command which is in the 'todo' state.
-func (s *TaskStorage) Get(ctx context.Context, action string, age time.Duration) (*taskworker.Task, error) {
-	task := &taskworker.Task{}
+// Retry moves all tasks for command in the 'doing' state started more than 'age' ago whose lease has expired back to
+// the 'todo' state, counting a failed attempt with LeaseExpired as its error. Tasks whose lease is still being extended
+// are left to their handler.
+func (s *TaskStorage) Retry(ctx context.Context, command string, age time.Duration) (int, error) {
+	var count int64
 
-	row := s.pool.QueryRowContext(ctx, `
+	err := transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
 		WITH moved_rows AS (
-			DELETE FROM workqueue.`+s.todoTable+`
+			DELETE FROM workqueue.`+s.doingTable+`
 			WHERE id IN (
 				SELECT id
-				FROM workqueue.`+s.todoTable+`
+				FROM workqueue.`+s.doingTable+`
 				WHERE action = $1
-					AND age(current_timestamp, created_at) > $2
-				ORDER BY created_at ASC
-				LIMIT 1
+					AND started_at <= current_timestamp - make_interval(secs => $2)
+					AND lease_expires_at < current_timestamp
+				FOR UPDATE SKIP LOCKED
 			)
 			RETURNING *
+		), moved AS (
+			INSERT INTO workqueue.`+s.todoTable+`(`+carried+`, attempts, last_error)
+			SELECT `+carried+`, attempts + 1, $3
+			FROM moved_rows
+			RETURNING id
 		)
-		INSERT INTO workqueue.`+s.doingTable+`(id, task_id, action, data)
-		SELECT id, task_id, action, data
-		FROM moved_rows
-		RETURNING id, task_id, action, data, created_at, started_at;
-	`, action, age)
+		SELECT count(*)
+		FROM moved;
+	`, command, age.Seconds(), taskworker.LeaseExpired).Scan(&count)
+
+		if err != nil {
+			return errors.Wrap(err, "error occurred retrying stale tasks")
+		}
+
+		if count == 0 {
+			return nil
+		}
+
+		return s.notify(ctx, tx, command)
+	})
+
+	return int(count), err
+}
 
+// Cleanup removes all tasks for command in the 'done' state finished more than 'age' ago, along with the workflows
+// involving command whose tasks were all done by then.
+func (s *TaskStorage) Cleanup(ctx context.Context, command string, age time.Duration) (int, error) {
+	res, err := s.pool.ExecContext(ctx, `
+		DELETE FROM workqueue.`+s.doneTable+`
+		WHERE action = $1
+			AND finished_at <= current_timestamp - make_interval(secs => $2);
+	`, command, age.Seconds())
+
+	if err != nil {
+		return 0, errors.Wrap(err, "error occurred cleaning up tasks")
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "error occurred cleaning up tasks")
+	}
+
+	if _, err := s.pool.ExecContext(ctx, `
+		DELETE FROM workqueue.`+s.keyTable+`
+		WHERE action = $1
+			AND expires_at <= current_timestamp;
+	`, command); err != nil {
+		return 0, errors.Wrap(err, "error occurred cleaning up dedup keys")
+	}
+
+	// a workflow is kept while any of its tasks may still wait for the others
+	if _, err := s.pool.ExecContext(ctx, `
+		DELETE FROM workqueue.`+s.stepTable+`
+		WHERE workflow_id IN (
+			SELECT workflow_id
+			FROM workqueue.`+s.stepTable+`
+			GROUP BY workflow_id
+			HAVING bool_or(action = $1)
+				AND bool_and(state = 'done' AND finished_at <= current_timestamp - make_interval(secs => $2))
+		);
+	`, command, age.Seconds()); err != nil {
+		return 0, errors.Wrap(err, "error occurred cleaning up workflows")
+	}
+
+	return int(count), nil
+}
+
+// Result returns the last task for action with the given task ID in the 'done' state, with its result, or nil if there
+// is none.
+func (s *TaskStorage) Result(ctx context.Context, action string, taskID string) (*taskworker.Task, error) {
+	row := s.pool.QueryRowContext(ctx, `
+		SELECT `+carried+`, started_at, attempts, finished_at, result
+		FROM workqueue.`+s.doneTable+`
+		WHERE action = $1
+			AND task_id = $2
+		ORDER BY finished_at DESC
+		LIMIT 1;
+	`, action, taskID)
+
+	task := &taskworker.Task{}
+
+	var result []byte
 	if err := row.Scan(
 		&task.ID,
 		&task.TaskID,
 		&task.Action,
 		&task.Data,
 		&task.CreatedAt,
+		&task.Priority,
+		&task.GroupID,
+		&task.WorkflowID,
+		pq.Array(&task.Parents),
+		durationScanner{&task.Timeout},
 		&task.StartedAt,
+		&task.Attempts,
+		&task.FinishedAt,
+		&result,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 
-		return nil, errors.Wrap(err, "error occurred getting tasks")
+		return nil, errors.Wrap(err, "error occurred getting the task result")
+	}
+
+	if result != nil {
+		task.Result = json.RawMessage(result)
 	}
 
 	return task, nil
 }
 
-// GetBatch returns the next N Tasks for command which is in the 'todo' state.
-func (s *TaskStorage) GetBatch(ctx Context, command string, age time.Duration, n int) ([]*taskworker.Task, error) {
-	rows, err := s.pool.QueryContext(ctx, `
+// Complete moves a task to the 'done' state, storing its result.
+func (s *TaskStorage) Complete(ctx context.Context, task *taskworker.Task) error {
+	// a task without a result stores NULL rather than an empty document, which is not valid JSON
+	var result interface{}
+	if task.Result != nil {
+		encoded, err := json.Marshal(task.Result)
+		if err != nil {
+			return errors.Wrap(err, "error occurred encoding the task result")
+		}
+
+		result = string(encoded)
+	}
+
+	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `
 		WITH moved_rows AS (
-			DELETE FROM workqueue.`+s.todoTable+`
-			WHERE id IN (
-				SELECT id
-				FROM workqueue.`+s.todoTable+`
-				WHERE action = $1
-					AND age(current_timestamp, created_at) > $2
-				ORDER BY created_at ASC
-				LIMIT $3
-			)
+			DELETE FROM workqueue.`+s.doingTable+`
+			WHERE id = $1
+				AND lease_token = $3
+			RETURNING *
+		)
+		INSERT INTO workqueue.`+s.doneTable+`(`+carried+`, started_at, attempts, result)
+		SELECT `+carried+`, started_at, attempts, $2::JSONB
+		FROM moved_rows
+		RETURNING finished_at, group_id, workflow_id;
+	`, task.ID, result, task.LeaseToken)
+
+		if err := row.Scan(&task.FinishedAt, &task.GroupID, &task.WorkflowID); err != nil {
+			if err == sql.ErrNoRows {
+				return errors.New("task is no longer in the doing state")
+			}
+
+			return errors.Wrap(err, "error occurred completing the task")
+		}
+
+		if err := s.expire(ctx, tx, task.Action, task.TaskID); err != nil {
+			return err
+		}
+
+		if err := s.settle(ctx, tx, task.GroupID, 1, 0); err != nil {
+			return err
+		}
+
+		return s.unblock(ctx, tx, task.WorkflowID, task.TaskID)
+	})
+}
+
+// Fail moves the task back to the 'todo' state, increasing its attempts count, so it is claimable again after the delay.
+func (s *TaskStorage) Fail(ctx context.Context, task *taskworker.Task, reason string, delay time.Duration) error {
+	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `
+		WITH moved_rows AS (
+			DELETE FROM workqueue.`+s.doingTable+`
+			WHERE id = $1
+				AND lease_token = $4
+			RETURNING *
+		)
+		INSERT INTO workqueue.`+s.todoTable+`(`+carried+`, attempts, last_error, next_run_at)
+		SELECT `+carried+`, attempts + 1, $2, current_timestamp + make_interval(secs => $3)
+		FROM moved_rows
+		RETURNING attempts, next_run_at;
+	`, task.ID, reason, delay.Seconds(), task.LeaseToken)
+
+		if err := row.Scan(&task.Attempts, &task.NextRunAt); err != nil {
+			if err == sql.ErrNoRows {
+				return errors.New("task is no longer in the doing state")
+			}
+
+			return errors.Wrap(err, "error occurred failing the task")
+		}
+
+		task.LastError = reason
+
+		if delay > 0 {
+			return nil
+		}
+
+		return s.notify(ctx, tx, task.Action)
+	})
+}
+
+// Bury moves the task to the 'dead' state, where it stays until it is requeued or purged.
+func (s *TaskStorage) Bury(ctx context.Context, task *taskworker.Task, reason string) error {
+	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `
+		WITH moved_rows AS (
+			DELETE FROM workqueue.`+s.doingTable+`
+			WHERE id = $1
+				AND lease_token = $3
+			RETURNING *
+		)
+		INSERT INTO workqueue.`+s.deadTable+`(`+carried+`, attempts, last_error)
+		SELECT `+carried+`, attempts + 1, $2
+		FROM moved_rows
+		RETURNING attempts, dead_at, group_id, workflow_id;
+	`, task.ID, reason, task.LeaseToken)
+
+		if err := row.Scan(&task.Attempts, &task.DeadAt, &task.GroupID, &task.WorkflowID); err != nil {
+			if err == sql.ErrNoRows {
+				return errors.New("task is no longer in the doing state")
+			}
+
+			return errors.Wrap(err, "error occurred burying the task")
+		}
+
+		task.LastError = reason
+
+		if err := s.drop(ctx, tx, task.Action, task.TaskID); err != nil {
+			return err
+		}
+
+		if err := s.settle(ctx, tx, task.GroupID, 0, 1); err != nil {
+			return err
+		}
+
+		return s.cascade(ctx, tx, task.WorkflowID, task.TaskID)
+	})
+}
+
+// Snooze moves the task back to the 'todo' state without counting an attempt, so it is claimable again once until passes.
+func (s *TaskStorage) Snooze(ctx context.Context, task *taskworker.Task, until time.Time) error {
+	delay := delayUntil(until)
+
+	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `
+		WITH moved_rows AS (
+			DELETE FROM workqueue.`+s.doingTable+`
+			WHERE id = $1
+				AND lease_token = $3
 			RETURNING *
 		)
-		INSERT INTO workqueue.`+s.doingTable+`(id, task_id, action, data)
-		SELECT id, task_id, action, data
+		INSERT INTO workqueue.`+s.todoTable+`(`+carried+`, attempts, last_error, next_run_at)
+		SELECT `+carried+`, attempts, last_error, current_timestamp + make_interval(secs => $2)
 		FROM moved_rows
-		RETURNING id, task_id, action, data, created_at, started_at;
-	`, command, age, n)
+		RETURNING next_run_at;
+	`, task.ID, delay.Seconds(), task.LeaseToken)
+
+		if err := row.Scan(&task.NextRunAt); err != nil {
+			if err == sql.ErrNoRows {
+				return errors.New("task is no longer in the doing state")
+			}
+
+			return errors.Wrap(err, "error occurred snoozing the task")
+		}
+
+		if delay > 0 {
+			return nil
+		}
+
+		return s.notify(ctx, tx, task.Action)
+	})
+}
+
+// Discard removes the task without moving it to the 'done' nor the 'dead' state. It counts as completed both for its
+// group and for the tasks waiting for it, since the work it stood for is no longer needed.
+func (s *TaskStorage) Discard(ctx context.Context, task *taskworker.Task) error {
+	return transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `
+		DELETE FROM workqueue.`+s.doingTable+`
+		WHERE id = $1
+			AND lease_token = $2
+		RETURNING group_id, workflow_id;
+	`, task.ID, task.LeaseToken)
+
+		if err := row.Scan(&task.GroupID, &task.WorkflowID); err != nil {
+			if err == sql.ErrNoRows {
+				return errors.New("task is no longer in the doing state")
+			}
+
+			return errors.Wrap(err, "error occurred discarding the task")
+		}
+
+		if err := s.drop(ctx, tx, task.Action, task.TaskID); err != nil {
+			return err
+		}
+
+		if err := s.settle(ctx, tx, task.GroupID, 1, 0); err != nil {
+			return err
+		}
+
+		return s.unblock(ctx, tx, task.WorkflowID, task.TaskID)
+	})
+}
+
+// DeadTasks returns up to N tasks in the 'dead' state for action, most recent first. An empty action matches all actions.
+func (s *TaskStorage) DeadTasks(ctx context.Context, action string, n int) ([]*taskworker.Task, error) {
+	rows, err := s.pool.QueryContext(ctx, `
+		SELECT `+carried+`, attempts, COALESCE(last_error, ''), dead_at
+		FROM workqueue.`+s.deadTable+`
+		WHERE $1 = '' OR action = $1
+		ORDER BY dead_at DESC
+		LIMIT $2;
+	`, action, n)
 
 	if err != nil {
-		return nil, errors.Wrap(err, "error occurred getting tasks")
+		return nil, errors.Wrap(err, "error occurred getting dead tasks")
 	}
 
 	defer rows.Close()
@@ -170,10 +867,6 @@ func (s *TaskStorage) GetBatch(ctx Context, command string, age time.Duration, n
 	tasks := make([]*taskworker.Task, 0)
 
 	for rows.Next() {
-		if err = rows.Err(); err != nil {
-			return tasks, errors.Wrap(err, "rows.Next row has error")
-		}
-
 		task := &taskworker.Task{}
 
 		if err := rows.Scan(
@@ -182,59 +875,195 @@ func (s *TaskStorage) GetBatch(ctx Context, command string, age time.Duration, n
 			&task.Action,
 			&task.Data,
 			&task.CreatedAt,
-			&task.StartedAt,
+			&task.Priority,
+			&task.GroupID,
+			&task.WorkflowID,
+			pq.Array(&task.Parents),
+			durationScanner{&task.Timeout},
+			&task.Attempts,
+			&task.LastError,
+			&task.DeadAt,
 		); err != nil {
-			return nil, errors.Wrap(err, "error occurred getting tasks")
+			return nil, errors.Wrap(err, "error occurred getting dead tasks")
 		}
 
 		tasks = append(tasks, task)
 	}
 
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred getting dead tasks")
+	}
+
 	return tasks, nil
 }
 
-// Retry marks all tasks for command in the state 'done' and older than 'age' back to the 'todo' state.
-func (s *TaskStorage) Retry(command string, age time.Duration) error {
+// Requeue moves a task in the 'dead' state back to the 'todo' state with its attempts reset.
+func (s *TaskStorage) Requeue(ctx context.Context, id int) error {
+	n, err := s.requeue(ctx, "id = $1", id)
+	if err != nil {
+		return errors.Wrap(err, "error occurred requeuing the task")
+	}
+
+	if n == 0 {
+		return errors.New("task is not in the dead state")
+	}
+
 	return nil
 }
 
-// Cleanup removes all tasks for command in the 'done' older than 'age'
-func (s *TaskStorage) Cleanup(command string, age time.Duration) error {
-	return nil
+// RequeueAll moves all tasks for action in the 'dead' state back to the 'todo' state. An empty action matches all actions.
+func (s *TaskStorage) RequeueAll(ctx context.Context, action string) (int, error) {
+	n, err := s.requeue(ctx, "$1 = '' OR action = $1", action)
+	if err != nil {
+		return 0, errors.Wrap(err, "error occurred requeuing tasks")
+	}
+
+	return n, nil
 }
 
-// Complete marks a task as complete.
-func (s *TaskStorage) Complete(task *taskworker.Task) error {
-	return transaction.InTransaction(ctx, r.pool, func(ctx context.Context, tx *sql.Tx) error {
+// requeue moves the tasks in the 'dead' state matching the condition back to the 'todo' state. Requeued members of
+// groups which did not finish yet stop counting as dead, and requeued tasks wait again for the parents of their workflow
+// which are not done.
+func (s *TaskStorage) requeue(ctx context.Context, condition string, args ...interface{}) (int, error) {
+	var count int
 
-		_, err := s.pool.ExecContext(ctx, `
-		DELETE FROM workqueue.`+s.doingTable+`
-		WHERE id = $1
-	`, task.ID)
+	err := transaction.InTransaction(ctx, s.pool, func(ctx context.Context, tx *sql.Tx) error {
+		// the parents are locked first, so they are not done meanwhile without the requeued tasks counting it
+		if _, err := tx.ExecContext(ctx, `
+		SELECT 1
+		FROM workqueue.`+s.stepTable+`
+		WHERE (workflow_id, task_id) IN (
+			SELECT workflow_id, unnest(parents)
+			FROM workqueue.`+s.deadTable+`
+			WHERE `+condition+`
+		)
+		FOR SHARE;
+	`, args...); err != nil {
+			return err
+		}
 
-		if err != nil {
-			return errors.Wrap(err, "error occurred completing the task")
+		var claimable []string
+
+		if err := tx.QueryRowContext(ctx, `
+		WITH moved_rows AS (
+			DELETE FROM workqueue.`+s.deadTable+`
+			WHERE `+condition+`
+			RETURNING *
+		), requeued AS (
+			INSERT INTO workqueue.`+s.todoTable+`(`+carried+`, last_error, pending)
+			SELECT `+carried+`, last_error, `+s.pending("moved_rows.workflow_id", "moved_rows.parents")+`
+			FROM moved_rows
+			RETURNING action, task_id, group_id, workflow_id, pending
+		), restepped AS (
+			UPDATE workqueue.`+s.stepTable+` s
+			SET state = 'waiting'
+			FROM requeued r
+			WHERE s.workflow_id = r.workflow_id
+				AND s.task_id = r.task_id
+		), held AS (
+			INSERT INTO workqueue.`+s.keyTable+`(action, task_id)
+			SELECT DISTINCT action, task_id
+			FROM requeued
+			ON CONFLICT (action, task_id) DO UPDATE SET expires_at = NULL
+		), regrouped AS (
+			UPDATE workqueue.`+s.groupTable+` g
+			SET dead = g.dead - r.n
+			FROM (SELECT group_id, count(*) AS n FROM requeued GROUP BY group_id) r
+			WHERE g.group_id = r.group_id
+				AND g.fired_at IS NULL
+		)
+		SELECT count(*), array_agg(DISTINCT action) FILTER (WHERE pending = 0)
+		FROM requeued;
+	`, args...).Scan(&count, pq.Array(&claimable)); err != nil {
+			return err
 		}
 
-		return err
+		return s.notify(ctx, tx, claimable...)
 	})
 
+	return count, err
 }
 
-// Fail fails the task and increase retries count.
-func (s *TaskStorage) Fail(task *taskworker.Task, reason string) error {
-	/*_, err := s.conn.Exec(`
-		UPDATE taskworker.tasks
-		SET state = CASE WHEN retries + 1 < 5 THEN $2 ELSE $3 END,
-			retries = CASE WHEN retries < 5 THEN retries + 1 ELSE retries END,
-			updated_at = current_timestamp,
-			fail_reason = $4
-		WHERE id = $1
-	`, task.ID, TaskStateToDo, TaskStateFailed, reason)
+// Purge removes all tasks for action in the 'dead' state. An empty action matches all actions.
+func (s *TaskStorage) Purge(ctx context.Context, action string) (int, error) {
+	var count int
+
+	err := s.pool.QueryRowContext(ctx, `
+		WITH purged AS (
+			DELETE FROM workqueue.`+s.deadTable+`
+			WHERE $1 = '' OR action = $1
+			RETURNING workflow_id, task_id
+		), unstepped AS (
+			DELETE FROM workqueue.`+s.stepTable+` s
+			USING purged p
+			WHERE s.workflow_id = p.workflow_id
+				AND s.task_id = p.task_id
+		)
+		SELECT count(*) FROM purged;
+	`, action).Scan(&count)
 
 	if err != nil {
-		return errors.Wrap(err, "error occurred failing the task")
-	}*/
+		return 0, errors.Wrap(err, "error occurred purging dead tasks")
+	}
+
+	return count, nil
+}
+
+// delayUntil converts a point in time to a delay from now, so the database clock is used to store it. A zero time
+// means no delay.
+func delayUntil(t time.Time) time.Duration {
+	if t.IsZero() {
+		return 0
+	}
+
+	return time.Until(t)
+}
+
+// likePatterns converts action patterns to LIKE patterns, where a trailing '*' matches any suffix.
+func likePatterns(actions []string) []string {
+	escape := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+	patterns := make([]string, 0, len(actions))
+	for _, action := range actions {
+		if strings.HasSuffix(action, "*") {
+			patterns = append(patterns, escape.Replace(strings.TrimSuffix(action, "*"))+"%")
+
+			continue
+		}
+
+		patterns = append(patterns, escape.Replace(action))
+	}
+
+	return patterns
+}
+
+// durationScanner scans a duration stored in milliseconds.
+type durationScanner struct {
+	duration *time.Duration
+}
+
+// Scan implements sql.Scanner.
+func (d durationScanner) Scan(src interface{}) error {
+	ms, ok := src.(int64)
+	if !ok {
+		return errors.Errorf("cannot scan %T into a duration", src)
+	}
+
+	*d.duration = time.Duration(ms) * time.Millisecond
 
 	return nil
 }
+
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// newLeaseToken returns a random token identifying a claim.
+func newLeaseToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", errors.Wrap(err, "error occurred generating the lease token")
+	}
+
+	return hex.EncodeToString(token), nil
+}
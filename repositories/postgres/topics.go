@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Topics is a topic registry stored in the database, so the subscriptions made by any process are seen by every
+// dispatcher using it.
+type Topics struct {
+	pool *sql.DB
+}
+
+// NewTopics creates a topic registry.
+func NewTopics(conn *sql.DB) Topics {
+	return Topics{pool: conn}
+}
+
+// Init prepares the storage, if needed, to keep the subscriptions of the topics.
+func (t *Topics) Init(ctx context.Context) error {
+	_, err := t.pool.ExecContext(ctx, `
+		CREATE SCHEMA IF NOT EXISTS workqueue;
+
+		CREATE TABLE IF NOT EXISTS workqueue.subscriptions
+		(
+			id BIGSERIAL PRIMARY KEY,
+			topic TEXT NOT NULL,
+			command TEXT NOT NULL,
+			subscribed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE (topic, command)
+		);
+	`)
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred creating the subscriptions table")
+	}
+
+	return nil
+}
+
+// Subscribe adds commands to the subscribers of topic. Commands which are already subscribed are ignored.
+func (t *Topics) Subscribe(ctx context.Context, topic string, commands ...string) error {
+	_, err := t.pool.ExecContext(ctx, `
+		INSERT INTO workqueue.subscriptions(topic, command)
+		SELECT $1, command
+		FROM unnest($2::TEXT[]) WITH ORDINALITY AS c(command, n)
+		ORDER BY n
+		ON CONFLICT (topic, command) DO NOTHING;
+	`, topic, pq.Array(commands))
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred subscribing to the topic")
+	}
+
+	return nil
+}
+
+// Unsubscribe removes a command from the subscribers of topic.
+func (t *Topics) Unsubscribe(ctx context.Context, topic string, command string) error {
+	_, err := t.pool.ExecContext(ctx, `
+		DELETE FROM workqueue.subscriptions
+		WHERE topic = $1
+			AND command = $2;
+	`, topic, command)
+
+	if err != nil {
+		return errors.Wrap(err, "error occurred unsubscribing from the topic")
+	}
+
+	return nil
+}
+
+// Subscribers returns the commands subscribed to topic, in the order they subscribed.
+func (t *Topics) Subscribers(ctx context.Context, topic string) ([]string, error) {
+	rows, err := t.pool.QueryContext(ctx, `
+		SELECT command
+		FROM workqueue.subscriptions
+		WHERE topic = $1
+		ORDER BY id;
+	`, topic)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred getting the topic subscribers")
+	}
+
+	defer rows.Close()
+
+	subscribers := make([]string, 0)
+	for rows.Next() {
+		var command string
+		if err := rows.Scan(&command); err != nil {
+			return nil, errors.Wrap(err, "error occurred getting the topic subscribers")
+		}
+
+		subscribers = append(subscribers, command)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "error occurred getting the topic subscribers")
+	}
+
+	return subscribers, nil
+}
@@ -0,0 +1,54 @@
+//go:build integration
+// +build integration
+
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+func TestTopicsAreShared(t *testing.T) {
+	RegisterTestingT(t)
+
+	storage, cleanup := newTestStorage(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	topic := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	defer storage.pool.Exec(`DELETE FROM workqueue.subscriptions WHERE topic = $1`, topic)
+
+	// each registry stands for another process subscribing or publishing
+	first := NewTopics(storage.pool)
+	Expect(first.Init(ctx)).To(Succeed())
+
+	second := NewTopics(storage.pool)
+	Expect(second.Init(ctx)).To(Succeed())
+
+	Expect(first.Subscribe(ctx, topic, "email", "sms")).To(Succeed())
+	Expect(second.Subscribe(ctx, topic, "sms", "push")).To(Succeed())
+
+	subscribers, err := second.Subscribers(ctx, topic)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(subscribers).To(Equal([]string{"email", "sms", "push"}))
+
+	dispatcher := taskworker.NewDispatcher(storage, taskworker.WithTopics(&second))
+	Expect(dispatcher.Publish(topic, "1", nil)).To(Equal(3))
+
+	for _, command := range subscribers {
+		task, err := storage.Get(ctx, command, 0, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(task).ToNot(BeNil(), "every subscriber should get its own task")
+	}
+
+	Expect(first.Unsubscribe(ctx, topic, "sms")).To(Succeed())
+
+	subscribers, err = second.Subscribers(ctx, topic)
+	Expect(err).ToNot(HaveOccurred())
+	Expect(subscribers).To(Equal([]string{"email", "push"}))
+}
@@ -0,0 +1,70 @@
+package taskworker
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy configures how many times and how soon a failed task is retried.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a task is handled before giving up on it. A value of 0 retries forever.
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry. Each following retry doubles it.
+	MinBackoff time.Duration
+	// MaxBackoff caps the delay between retries.
+	MaxBackoff time.Duration
+	// Jitter is the fraction of the delay, between 0 and 1, which is randomized so retries don't happen in bursts.
+	Jitter float64
+	// Overrides holds policies for specific actions which take precedence over this one.
+	Overrides map[string]RetryPolicy
+}
+
+// DefaultRetryPolicy returns the policy used by receivers unless configured otherwise.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		MinBackoff:  time.Second,
+		MaxBackoff:  time.Hour,
+		Jitter:      0.2,
+	}
+}
+
+// For returns the policy to apply to tasks of the given action.
+func (p RetryPolicy) For(action string) RetryPolicy {
+	if override, ok := p.Overrides[action]; ok {
+		return override
+	}
+
+	return p
+}
+
+// Exhausted tells if a task which failed the given number of attempts should not be retried anymore.
+func (p RetryPolicy) Exhausted(attempts int) bool {
+	return p.MaxAttempts > 0 && attempts >= p.MaxAttempts
+}
+
+// Backoff returns how long to wait before retrying a task which failed the given number of attempts.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delay := float64(p.MinBackoff) * math.Pow(2, float64(attempts-1))
+	if p.MaxBackoff > 0 && delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+
+	// without a max backoff the delay is still bounded by what a time.Duration can hold
+	delay = math.Min(delay, math.MaxInt64)
+
+	if p.Jitter > 0 {
+		delay -= delay * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+
+	if delay >= math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration(delay)
+}
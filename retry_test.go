@@ -0,0 +1,49 @@
+package taskworker
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	RegisterTestingT(t)
+
+	policy := RetryPolicy{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	Expect(policy.Backoff(1)).To(Equal(time.Second), "first retry should wait the min backoff")
+	Expect(policy.Backoff(3)).To(Equal(4*time.Second), "backoff should double on each attempt")
+	Expect(policy.Backoff(10)).To(Equal(10*time.Second), "backoff should be capped")
+
+	unbounded := RetryPolicy{MinBackoff: time.Second}
+
+	Expect(unbounded.Backoff(100)).To(Equal(time.Duration(math.MaxInt64)), "backoff should not overflow without a max")
+	Expect(unbounded.Backoff(5000)).To(Equal(time.Duration(math.MaxInt64)), "backoff should not overflow once it is infinite")
+
+	unbounded.Jitter = 0.5
+	Expect(unbounded.Backoff(5000)).To(BeNumerically(">", 0), "jitter should not overflow an unbounded backoff")
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	RegisterTestingT(t)
+
+	policy := RetryPolicy{MinBackoff: time.Second, MaxBackoff: time.Minute, Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		Expect(policy.Backoff(2)).To(BeNumerically("<=", 2*time.Second), "jitter should only shorten the backoff")
+		Expect(policy.Backoff(2)).To(BeNumerically(">=", time.Second), "jitter should not exceed its fraction")
+	}
+}
+
+func TestRetryPolicyOverrides(t *testing.T) {
+	RegisterTestingT(t)
+
+	policy := DefaultRetryPolicy()
+	policy.Overrides = map[string]RetryPolicy{"send-email": {MaxAttempts: 2}}
+
+	Expect(policy.For("send-email").Exhausted(2)).To(BeTrue(), "override should apply to its action")
+	Expect(policy.For("other").Exhausted(2)).To(BeFalse(), "default should apply to other actions")
+	Expect(RetryPolicy{}.Exhausted(1000)).To(BeFalse(), "zero max attempts should retry forever")
+}
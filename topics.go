@@ -0,0 +1,80 @@
+package taskworker
+
+import (
+	"context"
+	"sync"
+)
+
+// TopicRegistry keeps the commands subscribed to each topic. A task published to a topic is delivered to every
+// subscribed command as an independent task.
+type TopicRegistry interface {
+	// Subscribe adds commands to the subscribers of topic. Commands which are already subscribed are ignored.
+	Subscribe(ctx context.Context, topic string, commands ...string) error
+	// Unsubscribe removes a command from the subscribers of topic.
+	Unsubscribe(ctx context.Context, topic string, command string) error
+	// Subscribers returns the commands subscribed to topic, in the order they subscribed.
+	Subscribers(ctx context.Context, topic string) ([]string, error)
+}
+
+// Topics is a TopicRegistry kept in memory, so its subscriptions are only seen by the process which made them.
+type Topics struct {
+	mux         *sync.RWMutex
+	subscribers map[string][]string
+}
+
+// NewTopics creates an empty topic registry.
+func NewTopics() *Topics {
+	return &Topics{
+		mux:         &sync.RWMutex{},
+		subscribers: make(map[string][]string),
+	}
+}
+
+// Subscribe adds commands to the subscribers of topic. Commands which are already subscribed are ignored.
+func (t *Topics) Subscribe(ctx context.Context, topic string, commands ...string) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	for _, command := range commands {
+		if !contains(t.subscribers[topic], command) {
+			t.subscribers[topic] = append(t.subscribers[topic], command)
+		}
+	}
+
+	return nil
+}
+
+// Unsubscribe removes a command from the subscribers of topic.
+func (t *Topics) Unsubscribe(ctx context.Context, topic string, command string) error {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	subscribers := make([]string, 0, len(t.subscribers[topic]))
+	for _, subscriber := range t.subscribers[topic] {
+		if subscriber != command {
+			subscribers = append(subscribers, subscriber)
+		}
+	}
+
+	t.subscribers[topic] = subscribers
+
+	return nil
+}
+
+// Subscribers returns the commands subscribed to topic, in the order they subscribed.
+func (t *Topics) Subscribers(ctx context.Context, topic string) ([]string, error) {
+	t.mux.RLock()
+	defer t.mux.RUnlock()
+
+	return append([]string(nil), t.subscribers[topic]...), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
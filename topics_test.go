@@ -0,0 +1,42 @@
+package taskworker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/psimoesSsimoes/go-task-fanout/repositories/memory"
+	taskworker "gitlab.com/marcoxavier/go-taskworker"
+)
+
+func TestPublishFansOut(t *testing.T) {
+	RegisterTestingT(t)
+
+	ctx := context.Background()
+	storage := memory.NewTaskStorage()
+	topics := taskworker.NewTopics()
+	dispatcher := taskworker.NewDispatcher(storage, taskworker.WithTopics(topics))
+
+	_, err := dispatcher.Publish("orders", "1", nil)
+	Expect(err).To(HaveOccurred(), "a topic without subscribers should be rejected")
+
+	Expect(topics.Subscribe(ctx, "orders", "email", "sms", "email")).To(Succeed())
+	Expect(topics.Subscribers(ctx, "orders")).To(Equal([]string{"email", "sms"}))
+
+	Expect(dispatcher.Publish("orders", "1", nil)).To(Equal(2))
+
+	email, _ := storage.Get(ctx, "email", 0, time.Minute)
+	Expect(email).ToNot(BeNil())
+	sms, _ := storage.Get(ctx, "sms", 0, time.Minute)
+	Expect(sms).ToNot(BeNil())
+
+	Expect(storage.Complete(ctx, email)).To(Succeed())
+	Expect(storage.Fail(ctx, sms, "boom", 0)).To(Succeed(), "each subscriber should be retried independently")
+
+	Expect(topics.Subscribe(ctx, "orders", "push")).To(Succeed())
+	Expect(dispatcher.Publish("orders", "1", nil)).To(Equal(2), "subscribers with the task still waiting should be skipped")
+
+	Expect(topics.Unsubscribe(ctx, "orders", "email")).To(Succeed())
+	Expect(topics.Subscribers(ctx, "orders")).To(Equal([]string{"sms", "push"}))
+}
@@ -1,9 +1,13 @@
 package taskworker
 
 import (
+	"context"
 	"time"
 )
 
+// LeaseExpired is the last error of the tasks reclaimed once their lease expired, which counts as a failed attempt.
+const LeaseExpired = "lease expired"
+
 // Task is a work task
 type Task struct {
 	ID        int
@@ -12,24 +16,104 @@ type Task struct {
 	Action    string
 	CreatedAt time.Time
 	StartedAt time.Time
+	// GroupID is the group the task is a member of, if any.
+	GroupID string
+	// WorkflowID is the workflow the task is part of, if any.
+	WorkflowID string
+	// Parents are the IDs of the tasks of the same workflow which must be completed before the task can be claimed.
+	// The task is moved to the dead letter if any of them dies.
+	Parents []string
+	// Priority orders claiming, tasks with a higher priority are claimed first. Defaults to 0.
+	Priority int
+	// RunAt is the earliest time the task can be claimed. A zero RunAt means as soon as possible.
+	RunAt time.Time
+	// Timeout is how long the task may be handled before it fails. It overrides the receiver's task timeout when set.
+	Timeout time.Duration
+	// LeaseExpiresAt is when a claimed task is considered abandoned and may be reclaimed by another receiver.
+	LeaseExpiresAt time.Time
+	// LeaseToken identifies the claim holding the task. Once the task is reclaimed, the token it was claimed with no
+	// longer extends nor finishes it.
+	LeaseToken string
+	// Attempts is how many times handling the task has failed.
+	Attempts int
+	// LastError is the reason of the last failure.
+	LastError string
+	// NextRunAt is when a failed task becomes claimable again.
+	NextRunAt time.Time
+	// FinishedAt is when the task was completed.
+	FinishedAt time.Time
+	// Result is the output of the task, as returned by its ResultTaskHandler. It is stored, as JSON, once the task is
+	// completed. It is kept until the task is cleaned up and read back as a json.RawMessage.
+	Result interface{}
+	// DeadAt is when the task was moved to the dead letter.
+	DeadAt time.Time
 }
 
-// TaskStorage manages tasks.
+// TaskStorage manages tasks. Extend, Release, Complete, Fail, Bury, Snooze and Discard only act on tasks which are
+// still held by the claim their lease token comes from, so a receiver whose lease expired cannot finish a task which
+// was claimed again meanwhile.
 type TaskStorage interface {
-	// Create stores a task for processing.
-	Create(task *Task) error
-	// Get returns the next Task for command which is in the 'todo' state.
-	Get(command string, age time.Duration) (*Task, error)
-	// GetBatch returns the next N Tasks for command which is in the 'todo' state.
-	GetBatch(command string, age time.Duration, n int) ([]*Task, error)
-	// Retry marks all tasks for command in the state 'done' and older than 'age' back to the 'todo' state.
-	Retry(command string, age time.Duration) error
-	// Cleanup removes all tasks for command in the 'done' older than 'age'
-	Cleanup(command string, age time.Duration) error
-	// Complete marks a task as complete.
-	Complete(task *Task) error
-	// Fail fails the task and increase retries count.
-	Fail(task *Task, reason string) error
+	// Create stores a task for processing. It returns false, without storing it, if a task with the same action and ID
+	// is already in the 'todo' or 'doing' state. A task of a workflow fails instead, as do a task whose ID is taken
+	// within its workflow and a task whose parents are not part of its workflow or are dead.
+	Create(ctx context.Context, task *Task) (bool, error)
+	// CreateBatch stores several tasks for processing, either all of them or none, and returns how many were stored.
+	// Tasks which are deduplicated, as the same task is already waiting or running, are skipped rather than failing the
+	// batch. Parents must be stored before their children.
+	CreateBatch(ctx context.Context, tasks []*Task) (int, error)
+	// CreateGroup stores the tasks of a group for processing, either all of them or none. Completing or burying the
+	// last member adds the group callback.
+	CreateGroup(ctx context.Context, group *Group, tasks []*Task) error
+	// Get returns the next Task for command which is in the 'todo' state, leasing it for the given duration.
+	Get(ctx context.Context, command string, age time.Duration, lease time.Duration) (*Task, error)
+	// GetBatch returns the next N Tasks matching any of the action patterns which are in the 'todo' state, leasing them
+	// for the given duration under a new lease token. Tasks are claimed by highest priority first and in creation
+	// order within the same priority. See MatchAction for the pattern syntax.
+	GetBatch(ctx context.Context, actions []string, age time.Duration, lease time.Duration, n int) ([]*Task, error)
+	// Extend pushes the lease of a task in the 'doing' state to the given duration from now.
+	Extend(ctx context.Context, task *Task, lease time.Duration) error
+	// Reclaim moves all tasks matching any of the action patterns in the 'doing' state whose lease has expired back to
+	// the 'todo' state, counting a failed attempt with LeaseExpired as its error.
+	Reclaim(ctx context.Context, actions []string) (int, error)
+	// Release moves tasks which were claimed but never handled back to the 'todo' state.
+	Release(ctx context.Context, tasks []*Task) error
+	// Retry moves all tasks for command in the 'doing' state started more than 'age' ago whose lease has expired back
+	// to the 'todo' state, counting a failed attempt with LeaseExpired as its error. Tasks whose lease is still being
+	// extended are left to their handler.
+	Retry(ctx context.Context, command string, age time.Duration) (int, error)
+	// Cleanup removes all tasks for command in the 'done' state finished more than 'age' ago, along with the
+	// workflows involving command whose tasks were all done by then.
+	Cleanup(ctx context.Context, command string, age time.Duration) (int, error)
+	// Result returns the last task for action with the given task ID in the 'done' state, with its result, or nil if
+	// there is none.
+	Result(ctx context.Context, action string, taskID string) (*Task, error)
+	// Complete moves a task to the 'done' state.
+	Complete(ctx context.Context, task *Task) error
+	// Fail moves the task back to the 'todo' state, increasing its attempts count, so it is claimable again after the delay.
+	Fail(ctx context.Context, task *Task, reason string, delay time.Duration) error
+	// Bury moves the task to the 'dead' state, where it stays until it is requeued or purged.
+	Bury(ctx context.Context, task *Task, reason string) error
+	// Snooze moves the task back to the 'todo' state without counting an attempt, so it is claimable again once until passes.
+	Snooze(ctx context.Context, task *Task, until time.Time) error
+	// Discard removes the task without moving it to the 'done' nor the 'dead' state. It counts as completed both for its
+	// group and for the tasks waiting for it.
+	Discard(ctx context.Context, task *Task) error
+	// DeadTasks returns up to N tasks in the 'dead' state for action, most recent first. An empty action matches all actions.
+	DeadTasks(ctx context.Context, action string, n int) ([]*Task, error)
+	// Requeue moves a task in the 'dead' state back to the 'todo' state with its attempts reset.
+	Requeue(ctx context.Context, id int) error
+	// RequeueAll moves all tasks for action in the 'dead' state back to the 'todo' state. An empty action matches all actions.
+	RequeueAll(ctx context.Context, action string) (int, error)
+	// Purge removes all tasks for action in the 'dead' state. An empty action matches all actions.
+	Purge(ctx context.Context, action string) (int, error)
+}
+
+// TaskNotifier signals when tasks become claimable, whether they are created, retried, snoozed, released, reclaimed or
+// requeued, so receivers don't have to wait for their next tick.
+type TaskNotifier interface {
+	// Subscribe returns a channel which receives a signal whenever a task matching any of the action patterns becomes
+	// claimable. Call the returned function to unsubscribe.
+	Subscribe(actions ...string) (<-chan bool, func())
 }
 
 // Logger as the name says, it do logging
@@ -0,0 +1,113 @@
+package taskworker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// Workflow builds a graph of tasks where each task only runs once its parents are completed. Task IDs identify the
+// tasks of a workflow, so they must be unique within it, while tasks of other workflows never satisfy a dependency.
+type Workflow struct {
+	id    string
+	tasks []*Task
+	err   error
+}
+
+// NewWorkflow creates an empty workflow with a random ID. If the ID cannot be generated, Tasks returns the error.
+func NewWorkflow() *Workflow {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return &Workflow{err: errors.Wrap(err, "error occurred generating the workflow id")}
+	}
+
+	return &Workflow{id: hex.EncodeToString(id)}
+}
+
+// ID returns the ID of the workflow, which WithWorkflow takes to add tasks to it once it is submitted.
+func (w *Workflow) ID() string {
+	return w.id
+}
+
+// Add adds a task to the workflow. Use WithParents to declare the tasks it depends on.
+func (w *Workflow) Add(command string, id string, data interface{}, opts ...DispatchOption) *Workflow {
+	task := &Task{
+		TaskID:     id,
+		Data:       data,
+		Action:     command,
+		WorkflowID: w.id,
+	}
+
+	for _, opt := range opts {
+		opt(task)
+	}
+
+	w.tasks = append(w.tasks, task)
+
+	return w
+}
+
+// Tasks validates the workflow and returns its tasks with parents before their children. It fails if the workflow ID
+// could not be generated, a task ID is repeated, a parent is not part of the workflow or the dependencies have a cycle.
+func (w *Workflow) Tasks() ([]*Task, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	index := make(map[string]*Task, len(w.tasks))
+	for _, task := range w.tasks {
+		if _, ok := index[task.TaskID]; ok {
+			return nil, errors.Errorf("task %s was added more than once", task.TaskID)
+		}
+
+		index[task.TaskID] = task
+	}
+
+	for _, task := range w.tasks {
+		for _, parent := range task.Parents {
+			if _, ok := index[parent]; !ok {
+				return nil, errors.Errorf("parent %s of task %s is not part of the workflow", parent, task.TaskID)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	marks := make(map[string]int, len(w.tasks))
+	sorted := make([]*Task, 0, len(w.tasks))
+
+	var visit func(task *Task) error
+	visit = func(task *Task) error {
+		switch marks[task.TaskID] {
+		case visiting:
+			return errors.Errorf("task %s depends on itself", task.TaskID)
+		case visited:
+			return nil
+		}
+
+		marks[task.TaskID] = visiting
+		for _, parent := range task.Parents {
+			if err := visit(index[parent]); err != nil {
+				return err
+			}
+		}
+		marks[task.TaskID] = visited
+
+		sorted = append(sorted, task)
+
+		return nil
+	}
+
+	for _, task := range w.tasks {
+		if err := visit(task); err != nil {
+			return nil, err
+		}
+	}
+
+	return sorted, nil
+}
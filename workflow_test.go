@@ -0,0 +1,51 @@
+package taskworker
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+func TestWorkflowOrdersParentsFirst(t *testing.T) {
+	RegisterTestingT(t)
+
+	tasks, err := NewWorkflow().
+		Add("publish", "3", nil, WithParents("2")).
+		Add("transform", "2", nil, WithParents("1")).
+		Add("fetch", "1", nil).
+		Tasks()
+
+	Expect(err).ToNot(HaveOccurred())
+	Expect(tasks).To(HaveLen(3))
+	Expect(tasks[0].TaskID).To(Equal("1"))
+	Expect(tasks[1].TaskID).To(Equal("2"))
+	Expect(tasks[2].TaskID).To(Equal("3"))
+}
+
+func TestWorkflowRejectsCycles(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, err := NewWorkflow().
+		Add("fetch", "1", nil, WithParents("3")).
+		Add("transform", "2", nil, WithParents("1")).
+		Add("publish", "3", nil, WithParents("2")).
+		Tasks()
+
+	Expect(err).To(HaveOccurred(), "a cycle should never be submitted")
+}
+
+func TestWorkflowRejectsInvalidTasks(t *testing.T) {
+	RegisterTestingT(t)
+
+	_, err := NewWorkflow().Add("fetch", "1", nil).Add("transform", "1", nil).Tasks()
+	Expect(err).To(HaveOccurred(), "task ids should be unique")
+
+	_, err = NewWorkflow().Add("fetch", "1", nil, WithParents("0")).Tasks()
+	Expect(err).To(HaveOccurred(), "parents should be part of the workflow")
+
+	workflow := NewWorkflow()
+	workflow.err = errors.New("no entropy")
+	_, err = workflow.Add("fetch", "1", nil).Tasks()
+	Expect(err).To(MatchError("no entropy"), "a workflow without an id should never be submitted")
+}